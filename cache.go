@@ -1,7 +1,11 @@
 package sudu
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	gcache "github.com/patrickmn/go-cache"
@@ -15,8 +19,15 @@ var cacheLock *sync.Mutex = &sync.Mutex{}
 type Cache struct {
 	Cache *gcache.Cache
 	Key   string
+
+	// 共享模式，相同Key并发运行的Sudu只有先行者真正执行任务
+	// 后来者直接订阅先行者已确认的条件，并共享其Wait结果
+	Shared bool
 }
 
+// 共享模式下，正在执行中的Sudu，按bucket和key登记
+var flights map[*gcache.Cache]map[string]*Sudu = map[*gcache.Cache]map[string]*Sudu{}
+
 func NewCache(ns, key string) *Cache {
 	return &Cache{
 		Cache: GetCacheBucket(ns),
@@ -25,19 +36,156 @@ func NewCache(ns, key string) *Cache {
 }
 
 func (c *Cache) Set(nvs []interface{}) {
+	atomic.AddInt64(&statsOf(c.Cache).saves, 1)
 	c.Cache.SetDefault(c.Key, nvs)
 }
 
 func (c *Cache) Get() []interface{} {
+	st := statsOf(c.Cache)
 	v, ok := c.Cache.Get(c.Key)
 	if ok {
+		atomic.AddInt64(&st.hits, 1)
 		return v.([]interface{})
 	}
+	atomic.AddInt64(&st.misses, 1)
 	return make([]interface{}, 0)
 }
 
+// 用于Sudu的还原，额外统计还原出legacy条件的次数
+func (c *Cache) restore() []interface{} {
+	nvs := c.Get()
+	if len(nvs) > 0 {
+		atomic.AddInt64(&statsOf(c.Cache).restores, 1)
+	}
+	return nvs
+}
+
 func (c *Cache) Delete() {
+	st := statsOf(c.Cache)
+	st.lock.Lock()
+	st.deleting[c.Key]++
+	st.lock.Unlock()
+
 	c.Cache.Delete(c.Key)
+
+	st.lock.Lock()
+	if st.deleting[c.Key]--; st.deleting[c.Key] == 0 {
+		delete(st.deleting, c.Key)
+	}
+	st.lock.Unlock()
+}
+
+// 从外部数据源预热，使新的Sudu一开始就有可用的legacy条件
+// fx返回的格式同Set：name, value, [name, value, ...]
+func (c *Cache) Warm(fx func() ([]interface{}, error)) error {
+	nvs, err := fx()
+	if err != nil {
+		return err
+	}
+	c.Set(nvs)
+	return nil
+}
+
+// 数据格式即Sudu.Conditions()导出的json
+// 注意经过json还原的数值均为float64，对类型敏感的条件应当使用Warm自行解码
+func (c *Cache) WarmJSON(data []byte) error {
+	return c.Warm(func() ([]interface{}, error) {
+		return decodeConditions(data)
+	})
+}
+
+func (c *Cache) WarmFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return c.WarmJSON(data)
+}
+
+// 批量预热ns下的多个key，通常在启动时调用
+func Preload(ns string, fx func(key string) ([]interface{}, error), keys ...string) error {
+	for _, key := range keys {
+		key := key
+		err := NewCache(ns, key).Warm(func() ([]interface{}, error) {
+			return fx(key)
+		})
+		if err != nil {
+			return fmt.Errorf("preload %s/%s: %w", ns, key, err)
+		}
+	}
+	return nil
+}
+
+// 快照格式：{"key": [name, value, ...], ...}，与Snapshot的输出一致
+func PreloadJSON(ns string, data []byte) error {
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	snapshot := make(map[string][]interface{}, len(raw))
+	keys := make([]string, 0, len(raw))
+	for key, v := range raw {
+		nvs, err := decodeConditions(v)
+		if err != nil {
+			return fmt.Errorf("preload %s/%s: %w", ns, key, err)
+		}
+		snapshot[key] = nvs
+		keys = append(keys, key)
+	}
+
+	return Preload(ns, func(key string) ([]interface{}, error) {
+		return snapshot[key], nil
+	}, keys...)
+}
+
+func PreloadFile(ns, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return PreloadJSON(ns, data)
+}
+
+// 将ns下全部的key导出为快照，可用于PreloadJSON
+// 名字不是json标量的条件（例如ScopedName）无法还原，不会导出
+func Snapshot(ns string) ([]byte, error) {
+	snapshot := make(map[string]interface{})
+	for key, item := range GetCacheBucket(ns).Items() {
+		if nvs, ok := item.Object.([]interface{}); ok {
+			snapshot[key] = scalarConditions(nvs)
+		}
+	}
+	return json.Marshal(snapshot)
+}
+
+func scalarConditions(nvs []interface{}) []interface{} {
+	scalar := make([]interface{}, 0, len(nvs))
+	for i := 0; i+1 < len(nvs); i += 2 {
+		switch nvs[i].(type) {
+		case nil, string, bool, int, int8, int16, int32, int64,
+			uint, uint8, uint16, uint32, uint64, float32, float64:
+			scalar = append(scalar, nvs[i], nvs[i+1])
+		}
+	}
+	return scalar
+}
+
+func decodeConditions(data []byte) ([]interface{}, error) {
+	nvs := make([]interface{}, 0)
+	if err := json.Unmarshal(data, &nvs); err != nil {
+		return nil, err
+	}
+	if len(nvs)%2 == 1 {
+		return nil, fmt.Errorf("odd number of condition fields %d", len(nvs))
+	}
+	for i := 0; i < len(nvs); i += 2 {
+		switch nvs[i].(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("invalid condition name %v", nvs[i])
+		}
+	}
+	return nvs, nil
 }
 
 func GetCacheBucket(ns string) *gcache.Cache {
@@ -49,8 +197,40 @@ func GetCacheBucket(ns string) *gcache.Cache {
 		// 24h ok
 		// every 1h exec cache cleaner
 		gc = gcache.New(24*time.Hour, 1*time.Hour)
+		gc.OnEvicted(statsOf(gc).evicted)
 		defaultCaches[ns] = gc
 	}
 
 	return gc
 }
+
+// 登记为先行者，如果已有相同Key的先行者在执行中，则返回该先行者
+func (c *Cache) join(sd *Sudu) *Sudu {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	m := flights[c.Cache]
+	if m == nil {
+		m = make(map[string]*Sudu)
+		flights[c.Cache] = m
+	}
+	if leader := m[c.Key]; leader != nil {
+		return leader
+	}
+	m[c.Key] = sd
+	return nil
+}
+
+// 先行者执行结束，注销登记，之后相同Key的Sudu将重新开始执行
+func (c *Cache) leave(sd *Sudu) {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	m := flights[c.Cache]
+	if m != nil && m[c.Key] == sd {
+		delete(m, c.Key)
+		if len(m) == 0 {
+			delete(flights, c.Cache)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package sudu
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	gcache "github.com/patrickmn/go-cache"
+)
+
+// 每个bucket的使用统计，按bucket登记，GetCacheBucket创建的bucket额外统计移除事件
+var bucketStats map[*gcache.Cache]*cacheStats = map[*gcache.Cache]*cacheStats{}
+var statsLock *sync.Mutex = &sync.Mutex{}
+
+// namespace的使用统计快照
+type CacheStats struct {
+	Hits   int64
+	Misses int64
+	// 还原出了legacy条件的次数
+	Restores int64
+	Saves    int64
+	// 通过Delete主动移除的条目
+	Evictions int64
+	// 过期后被清理的条目
+	Expired int64
+	Entries int
+}
+
+type cacheStats struct {
+	hits      int64
+	misses    int64
+	restores  int64
+	saves     int64
+	evictions int64
+	expired   int64
+
+	// 正在Delete中的key，用于区分主动移除与过期清理
+	lock     sync.Mutex
+	deleting map[string]int
+}
+
+func statsOf(gc *gcache.Cache) *cacheStats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+
+	st := bucketStats[gc]
+	if st == nil {
+		st = &cacheStats{
+			deleting: make(map[string]int),
+		}
+		bucketStats[gc] = st
+	}
+	return st
+}
+
+// go-cache的OnEvicted，Delete与过期清理都会触发
+func (st *cacheStats) evicted(key string, _ interface{}) {
+	st.lock.Lock()
+	deleting := st.deleting[key] > 0
+	st.lock.Unlock()
+
+	if deleting {
+		atomic.AddInt64(&st.evictions, 1)
+	} else {
+		atomic.AddInt64(&st.expired, 1)
+	}
+}
+
+func GetCacheStats(ns string) CacheStats {
+	gc := GetCacheBucket(ns)
+	st := statsOf(gc)
+	return CacheStats{
+		Hits:      atomic.LoadInt64(&st.hits),
+		Misses:    atomic.LoadInt64(&st.misses),
+		Restores:  atomic.LoadInt64(&st.restores),
+		Saves:     atomic.LoadInt64(&st.saves),
+		Evictions: atomic.LoadInt64(&st.evictions),
+		Expired:   atomic.LoadInt64(&st.expired),
+		Entries:   gc.ItemCount(),
+	}
+}
+
+// 按key的顺序遍历ns下未过期的条目，以及其保存的条件名，fx返回false则停止遍历
+func RangeCacheBucket(ns string, fx func(key string, names []interface{}) bool) {
+	items := GetCacheBucket(ns).Items()
+
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		nvs, ok := items[key].Object.([]interface{})
+		if ok == false {
+			continue
+		}
+		names := make([]interface{}, 0, (len(nvs)+1)/2)
+		for i := 0; i < len(nvs); i += 2 {
+			names = append(names, nvs[i])
+		}
+		if fx(key, names) == false {
+			return
+		}
+	}
+}
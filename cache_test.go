@@ -0,0 +1,129 @@
+package sudu
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCacheWarm(t *testing.T) {
+	{
+		sd := NewSudu(nil)
+		sd.Go(func(cg *ConditionGroup) {
+			cg.Satisfy("str", "test")
+		})
+		sd.Wait()
+
+		data, err := json.Marshal(sd.Conditions())
+		if err != nil {
+			t.Fatalf("marshal error %v", err)
+		}
+		if err := NewCache("warm", "json").WarmJSON(data); err != nil {
+			t.Fatalf("warm error %v", err)
+		}
+	}
+
+	err := Preload("warm", func(key string) ([]interface{}, error) {
+		return []interface{}{"key", key}, nil
+	}, "k1", "k2")
+	if err != nil {
+		t.Fatalf("preload error %v", err)
+	}
+
+	snapshot, err := Snapshot("warm")
+	if err != nil {
+		t.Fatalf("snapshot error %v", err)
+	}
+	if err := PreloadJSON("warm2", snapshot); err != nil {
+		t.Fatalf("preload json error %v", err)
+	}
+
+	for _, key := range []string{"k1", "k2"} {
+		sd := NewSudu(NewCache("warm2", key))
+		v, _, ok := sd.Inspect("key")
+		if ok == false || v != key || sd.legacy["key"] == false {
+			t.Errorf("key %s not warmed as legacy, got %v", key, v)
+		}
+	}
+
+	sd := NewSudu(NewCache("warm2", "json"))
+	if v, _, _ := sd.Inspect("str"); v != "test" {
+		t.Errorf("str = %v, expect %v", v, "test")
+	}
+
+	err = NewCache("warm", "fail").Warm(func() ([]interface{}, error) {
+		return nil, fmt.Errorf("unavailable")
+	})
+	if err == nil {
+		t.Errorf("expect warm error")
+	}
+}
+
+func TestCacheSnapshotScoped(t *testing.T) {
+	ns := fmt.Sprintf("scoped-%d", time.Now().UnixNano())
+	NewCache(ns, "k").Set([]interface{}{ScopedName{"s", "A"}, 1, "B", 2})
+
+	// 名字不是json标量的条件不会导出，其余的条件照常预热
+	snapshot, err := Snapshot(ns)
+	if err != nil {
+		t.Fatalf("snapshot error %v", err)
+	}
+	if err := PreloadJSON(ns+"2", snapshot); err != nil {
+		t.Fatalf("preload json error %v", err)
+	}
+	if nvs := NewCache(ns+"2", "k").Get(); len(nvs) != 2 || nvs[0] != "B" {
+		t.Errorf("nvs = %v, expect [B 2]", nvs)
+	}
+
+	if err := PreloadJSON(ns+"3", []byte(`{"k": ["A", 1, "B"]}`)); err == nil {
+		t.Errorf("expect error for odd number of fields")
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	ns := fmt.Sprintf("stats-%d", time.Now().UnixNano())
+	c := NewCache(ns, "k1")
+	c.Get()
+
+	sd := NewSudu(c)
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("A", 1, "B", 2)
+	})
+	sd.Wait()
+
+	NewSudu(c)
+	NewCache(ns, "k2").Set([]interface{}{"C", 3})
+	NewCache(ns, "k3").Set(nil)
+
+	keys := make([]string, 0)
+	RangeCacheBucket(ns, func(key string, names []interface{}) bool {
+		keys = append(keys, key)
+		if key == "k1" && len(names) != 2 {
+			t.Errorf("k1 names = %v, expect 2 names", names)
+		}
+		return true
+	})
+	if fmt.Sprint(keys) != "[k1 k2 k3]" {
+		t.Errorf("keys = %v", keys)
+	}
+
+	NewCache(ns, "k3").Delete()
+	GetCacheBucket(ns).Set("k4", []interface{}{}, time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	GetCacheBucket(ns).DeleteExpired()
+
+	st := GetCacheStats(ns)
+	expect := CacheStats{
+		Hits:      1,
+		Misses:    2,
+		Restores:  1,
+		Saves:     3,
+		Evictions: 1,
+		Expired:   1,
+		Entries:   2,
+	}
+	if st != expect {
+		t.Errorf("stats = %+v, expect %+v", st, expect)
+	}
+}
@@ -0,0 +1,81 @@
+package sudu
+
+// 暂存的写入，读取时优先于group中的条件，提交之前对其他人不可见
+type condBuffer struct {
+	values map[interface{}]*cValue
+	ops    []bufferedOp
+	// 已被丢弃，不再向father传播读事件
+	detached bool
+}
+
+type bufferedOp struct {
+	cancel  bool
+	retract bool
+	nvs     []interface{}
+}
+
+// 返回一个写入被暂存的clone，读事件依然传播到cg，阻塞不再向上报告
+// 用于同时执行同一任务的多次尝试，只提交其中一次的写入
+func (cg *ConditionGroup) buffered() *ConditionGroup {
+	cg2 := cg.child()
+	cg2.buffer = &condBuffer{
+		values: make(map[interface{}]*cValue),
+	}
+	return cg2
+}
+
+// 调用方需持有锁
+func (b *condBuffer) write(cancel bool, nvs []interface{}) {
+	if b.detached {
+		return
+	}
+	if len(nvs)%2 == 1 {
+		nvs = append(nvs, nil)
+	}
+	b.ops = append(b.ops, bufferedOp{cancel: cancel, nvs: nvs})
+	for i := 0; i < len(nvs); i += 2 {
+		if cancel {
+			b.values[nvs[i]] = &cValue{cmsg: newCancelMessage(nvs[i], nvs[i+1])}
+		} else {
+			b.values[nvs[i]] = &cValue{value: nvs[i+1]}
+		}
+	}
+}
+
+// 调用方需持有锁
+func (b *condBuffer) retract(names []interface{}) {
+	if b.detached {
+		return
+	}
+	b.ops = append(b.ops, bufferedOp{retract: true, nvs: names})
+	for _, name := range names {
+		b.values[name] = nil
+	}
+}
+
+// 丢弃暂存的写入，之后的读取不再被记录
+func (cg *ConditionGroup) detach() {
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	cg.buffer.detached = true
+	cg.buffer.ops = nil
+}
+
+// 按顺序将暂存的写入提交到to
+func (cg *ConditionGroup) commit(to *ConditionGroup) {
+	cg.lock.Lock()
+	ops := cg.buffer.ops
+	cg.buffer.ops = nil
+	cg.lock.Unlock()
+
+	for _, op := range ops {
+		if op.retract {
+			to.Retract(op.nvs...)
+		} else if op.cancel {
+			to.Cancel(op.nvs...)
+		} else {
+			to.Satisfy(op.nvs...)
+		}
+	}
+}
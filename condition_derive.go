@@ -0,0 +1,121 @@
+package sudu
+
+import (
+	"fmt"
+)
+
+type derivation struct {
+	name   interface{}
+	inputs []interface{}
+	fx     func(values ...interface{}) interface{}
+}
+
+// 注册派生条件 name = fx(inputs...)
+// 全部输入就绪时自动满足，任一输入变更时重新计算，任一输入被取消时随之取消
+// 任一输入是legacy的，派生条件也是legacy的，输入全部确认后派生条件随之确认
+// fx发生panic时，派生条件以panic的值取消
+// 任一输入被retract时，派生条件随之retract
+// 同一个条件只能注册一次，派生关系也不能成环，否则panic
+func (cg *ConditionGroup) Derive(name interface{}, inputs []interface{}, fx func(values ...interface{}) interface{}) {
+	d := &derivation{
+		name:   cg.writable([]interface{}{name}, 1)[0],
+		inputs: cg.keys(inputs),
+		fx:     fx,
+	}
+	cg = cg.base()
+
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	if _, ok := cg.derived[d.name]; ok {
+		panic(fmt.Errorf("condition %v is already derived", d.name))
+	}
+	if cg.reaches(d.inputs, d.name, make(map[interface{}]bool)) {
+		panic(fmt.Errorf("condition %v derives from itself", d.name))
+	}
+
+	cg.derived[d.name] = d
+	cg.listenWriteEvent(func(names ...interface{}) {
+		for _, name := range names {
+			if d.depends(name) {
+				cg.derive(d)
+				return
+			}
+		}
+	})
+	cg.derive(d)
+}
+
+func (d *derivation) depends(name interface{}) bool {
+	for _, input := range d.inputs {
+		if input == name {
+			return true
+		}
+	}
+	return false
+}
+
+// inputs（以及它们派生自的条件）中是否包含name，调用方需持有锁
+func (cg *ConditionGroup) reaches(inputs []interface{}, name interface{}, visited map[interface{}]bool) bool {
+	for _, input := range inputs {
+		if input == name {
+			return true
+		}
+		if visited[input] {
+			continue
+		}
+		visited[input] = true
+		if d := cg.derived[input]; d != nil && cg.reaches(d.inputs, name, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// 调用方需持有锁
+func (cg *ConditionGroup) derive(d *derivation) {
+	values := make([]interface{}, 0, len(d.inputs))
+	legacy := false
+	var canceled *CancelMessage
+	for _, input := range d.inputs {
+		v, cmsg, ok := cg.inspect(input)
+		if ok == false {
+			// 输入未知，派生条件不能保留旧的值
+			_, has_value := cg.raw[d.name]
+			_, has_cmsg := cg.cmsgs[d.name]
+			if has_value || has_cmsg {
+				cg.retract(d.name)
+			}
+			return
+		}
+		if cmsg != nil && canceled == nil {
+			canceled = cmsg
+		}
+		legacy = legacy || cg.legacy[input]
+		values = append(values, v)
+	}
+
+	if canceled != nil {
+		cg.satisfy(true, legacy, d.name, canceled)
+		return
+	}
+
+	value, p := d.compute(values)
+	if p == nil {
+		if err := cg.check(d.name, value); err != nil {
+			p = err
+		}
+	}
+	if p != nil {
+		cg.satisfy(true, legacy, d.name, p)
+	} else {
+		cg.satisfy(false, legacy, d.name, value)
+	}
+}
+
+func (d *derivation) compute(values []interface{}) (value interface{}, p interface{}) {
+	defer func() {
+		p = recover()
+	}()
+	return d.fx(values...), nil
+}
@@ -0,0 +1,107 @@
+package sudu
+
+import (
+	"testing"
+)
+
+func sum(values ...interface{}) interface{} {
+	s := 0
+	for _, v := range values {
+		s += v.(int)
+	}
+	return s
+}
+
+func TestConditionDerive(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.Derive("C", []interface{}{"A", "B"}, sum)
+
+	cg.Satisfy("A", 1)
+	if _, _, ok := cg.Inspect("C"); ok {
+		t.Fatalf("expect C waiting for B")
+	}
+
+	cg.Satisfy("B", 2)
+	if c := cg.Require("C").(int); c != 3 {
+		t.Errorf("C = %d, expect %d", c, 3)
+	}
+
+	cg.Satisfy("A", 5)
+	if c := cg.Require("C").(int); c != 7 {
+		t.Errorf("C = %d, expect %d", c, 7)
+	}
+
+	cg.Cancel("B", "gone")
+	if _, cmsg := cg.Want("C"); cmsg == nil {
+		t.Errorf("expect C canceled")
+	}
+
+	cg.Satisfy("B", "x")
+	if _, cmsg := cg.Want("C"); cmsg == nil {
+		t.Errorf("expect C canceled by panic")
+	}
+}
+
+func TestConditionDeriveSudu(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.Derive("C", []interface{}{"A", "B"}, sum)
+
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("B", cg.Require("A").(int)+1)
+	})
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("D", cg.Require("C").(int)*2)
+	})
+
+	sd.SatisfyLegacy("B", 5)
+	sd.Satisfy("A", 1)
+	sd.Wait()
+
+	if d := sd.Require("D").(int); d != 6 {
+		t.Errorf("D = %d, expect %d", d, 6)
+	}
+	if len(sd.Unconfirmed()) != 0 {
+		t.Errorf("expect all confirmed, got %v", sd.Unconfirmed())
+	}
+
+	found := false
+	cs := sd.Conditions()
+	for i := 0; i < len(cs); i += 2 {
+		if cs[i] == "C" && cs[i+1] == 3 {
+			found = true
+		}
+	}
+	if found == false {
+		t.Errorf("expect C in conditions, got %v", cs)
+	}
+}
+
+func TestConditionDeriveRetract(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.Derive("B", []interface{}{"A"}, sum)
+	cg.Derive("C", []interface{}{"B"}, sum)
+
+	cg.Satisfy("A", 1)
+	if c := cg.Require("C").(int); c != 1 {
+		t.Errorf("C = %d, expect %d", c, 1)
+	}
+
+	cg.Retract("A")
+	if _, _, ok := cg.Inspect("C"); ok {
+		t.Errorf("expect C retracted along with A")
+	}
+
+	rejected := func(name interface{}, inputs ...interface{}) (ok bool) {
+		defer func() {
+			ok = recover() != nil
+		}()
+		cg.Derive(name, inputs, sum)
+		return
+	}
+	if rejected("C", "A") == false {
+		t.Errorf("expect duplicate derivation rejected")
+	}
+	if rejected("A", "C") == false || rejected("D", "D") == false {
+		t.Errorf("expect cyclic derivation rejected")
+	}
+}
@@ -1,11 +1,63 @@
 package sudu
 
 import (
+	"fmt"
+	"reflect"
 	"sync"
 )
 
+// 条件被取消的原因，同时也是Require的panic值，以及Sudu.Wait返回的error
 type CancelMessage struct {
+	// 被取消的条件，整体取消（例如任务出错）时为nil
+	Name    interface{}
 	Message interface{}
+	// 上游的原因，取消是由另一个取消或error引起时，沿Cause可以追溯完整的路径
+	Cause error
+}
+
+func (cmsg *CancelMessage) Error() string {
+	var prefix string
+	if cmsg.Name == nil {
+		prefix = "canceled"
+	} else {
+		prefix = fmt.Sprintf("condition %v canceled", cmsg.Name)
+	}
+	if cmsg.Message == nil {
+		return prefix
+	}
+	return fmt.Sprintf("%s: %v", prefix, cmsg.Message)
+}
+
+func (cmsg *CancelMessage) Unwrap() error {
+	return cmsg.Cause
+}
+
+// 取消的传播路径，从此条件开始，直到最初被取消的条件
+func (cmsg *CancelMessage) Path() []interface{} {
+	path := make([]interface{}, 0)
+	var err error = cmsg
+	for err != nil {
+		if c, ok := err.(*CancelMessage); ok && c.Name != nil {
+			path = append(path, c.Name)
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if ok == false {
+			break
+		}
+		err = u.Unwrap()
+	}
+	return path
+}
+
+func newCancelMessage(name, msg interface{}) *CancelMessage {
+	cmsg := &CancelMessage{
+		Name:    name,
+		Message: msg,
+	}
+	if err, ok := msg.(error); ok {
+		cmsg.Cause = err
+	}
+	return cmsg
 }
 
 // 条件依赖模型
@@ -21,8 +73,34 @@ type ConditionGroup struct {
 	legacy map[interface{}]bool
 	cmsg   *CancelMessage
 
+	// 以过期的值降级确认的条件，对下游而言与确认的条件相同，参见Sudu.Fallback
+	stale map[interface{}]bool
+
+	// 派生条件，由输入条件自动计算得出
+	derived map[interface{}]*derivation
+
+	// 每个条件的版本号，以及有界的写入历史
+	versions     map[interface{}]uint64
+	history      map[interface{}][]ConditionRecord
+	history_size int
+	// 写入者，即Sudu的任务id，不是由任务写入时为-1
+	writer int
+
+	// 条件的校验方法，Satisfy时执行
+	validators map[interface{}][]func(interface{}) error
+
+	// 作用域的声明，以及视图所在的作用域，根group的scope为nil
+	scopes map[string]*conditionScope
+	scope  *conditionScope
+	// 是否为Scope返回的视图
+	view bool
+
 	read_cbs  []func(name interface{})
-	write_cbs []func(names ...interface{})
+	write_cbs []*func(names ...interface{})
+	// Want阻塞等待以及被唤醒时触发，用于在等待期间让出执行名额
+	park_cbs []func(parked bool)
+	// 非nil时写入被暂存，参见buffered
+	buffer *condBuffer
 
 	// in legacy mode, Satisfy & Cancel will set the condition as legacy
 	legacy_mode bool
@@ -37,6 +115,17 @@ func NewConditionGroup() *ConditionGroup {
 		lsn:    make(map[interface{}]chan struct{}),
 		cmsgs:  make(map[interface{}]*CancelMessage),
 		legacy: make(map[interface{}]bool),
+		stale:  make(map[interface{}]bool),
+
+		derived: make(map[interface{}]*derivation),
+
+		versions: make(map[interface{}]uint64),
+		history:  make(map[interface{}][]ConditionRecord),
+		writer:   -1,
+
+		validators: make(map[interface{}][]func(interface{}) error),
+
+		scopes: make(map[string]*conditionScope),
 
 		lock:       &sync.Mutex{},
 		event_lock: &sync.Mutex{},
@@ -44,37 +133,94 @@ func NewConditionGroup() *ConditionGroup {
 }
 
 func (cg *ConditionGroup) clone() *ConditionGroup {
+	cg2 := cg.child()
+	cg2.legacy_mode = false
+	return cg2
+}
+
+// 共享条件的副本，不继承任何监听，事件沿father向上传播
+// 监听可能随时被注册（例如运行中添加任务），复制需要与注册互斥
+func (cg *ConditionGroup) child() *ConditionGroup {
+	cg.event_lock.Lock()
 	var cg2 ConditionGroup = *cg
+	cg.event_lock.Unlock()
+
 	cg2.father = cg
 	cg2.read_cbs = nil
 	cg2.write_cbs = nil
-	cg2.legacy_mode = false
+	cg2.park_cbs = nil
 	cg2.event_lock = &sync.Mutex{}
 	return &cg2
 }
 
+// 监听列表都是copy on write的，emit时只需取得当前的列表
 func (cg *ConditionGroup) listenReadEvent(fx func(interface{})) {
 	cg.event_lock.Lock()
 	defer cg.event_lock.Unlock()
-	if cg.read_cbs == nil {
-		cg.read_cbs = make([]func(interface{}), 0)
-	}
 
-	cg.read_cbs = append(cg.read_cbs, fx)
+	cbs := make([]func(interface{}), 0, len(cg.read_cbs)+1)
+	cg.read_cbs = append(append(cbs, cg.read_cbs...), fx)
 }
 
-func (cg *ConditionGroup) listenWriteEvent(fx func(...interface{})) {
+// 返回的函数用于取消监听，与emitWriteEvent一样需要在持有锁的情况下调用
+func (cg *ConditionGroup) listenWriteEvent(fx func(...interface{})) func() {
 	cg.event_lock.Lock()
 	defer cg.event_lock.Unlock()
-	if cg.write_cbs == nil {
-		cg.write_cbs = make([]func(...interface{}), 0)
+
+	cb := &fx
+	cbs := make([]*func(...interface{}), 0, len(cg.write_cbs)+1)
+	cg.write_cbs = append(append(cbs, cg.write_cbs...), cb)
+	return func() {
+		cg.event_lock.Lock()
+		defer cg.event_lock.Unlock()
+
+		// copy on write, emitWriteEvent可能正在遍历旧的列表
+		cbs := make([]*func(...interface{}), 0, len(cg.write_cbs))
+		for _, v := range cg.write_cbs {
+			if v != cb {
+				cbs = append(cbs, v)
+			}
+		}
+		cg.write_cbs = cbs
 	}
+}
+
+func (cg *ConditionGroup) listenParkEvent(fx func(bool)) {
+	cg.event_lock.Lock()
+	defer cg.event_lock.Unlock()
 
-	cg.write_cbs = append(cg.write_cbs, fx)
+	cbs := make([]func(bool), 0, len(cg.park_cbs)+1)
+	cg.park_cbs = append(append(cbs, cg.park_cbs...), fx)
+}
+
+func (cg *ConditionGroup) emitParkEvent(parked bool) {
+	cg.event_lock.Lock()
+	park_cbs := cg.park_cbs
+	cg.event_lock.Unlock()
+
+	for _, cb := range park_cbs {
+		cb(parked)
+	}
+
+	// 暂存写入的尝试各自占用名额，由发起者分别处理
+	if cg.father != nil && cg.buffer == nil {
+		cg.father.emitParkEvent(parked)
+	}
 }
 
 func (cg *ConditionGroup) emitReadEvent(name interface{}) {
-	for _, cb := range cg.read_cbs {
+	if b := cg.buffer; b != nil {
+		// 读到的是自己暂存的写入，或者已被丢弃
+		if _, ok := b.values[name]; ok || b.detached {
+			return
+		}
+	}
+
+	cg.event_lock.Lock()
+	read_cbs := cg.read_cbs
+	cg.event_lock.Unlock()
+
+	for _, cb := range read_cbs {
 		cb(name)
 	}
 
@@ -84,8 +230,12 @@ func (cg *ConditionGroup) emitReadEvent(name interface{}) {
 }
 
 func (cg *ConditionGroup) emitWriteEvent(names ...interface{}) {
-	for _, cb := range cg.write_cbs {
-		cb(names...)
+	cg.event_lock.Lock()
+	write_cbs := cg.write_cbs
+	cg.event_lock.Unlock()
+
+	for _, cb := range write_cbs {
+		(*cb)(names...)
 	}
 
 	if cg.father != nil {
@@ -107,14 +257,25 @@ func (cg *ConditionGroup) conditions() (names []interface{}) {
 }
 
 func (cg *ConditionGroup) inspect(name interface{}) (interface{}, *CancelMessage, bool) {
+	if cg.buffer != nil {
+		if cv, ok := cg.buffer.values[name]; ok {
+			if cv == nil {
+				return nil, nil, false
+			}
+			return cv.value, cv.cmsg, true
+		}
+	}
 	if v, ok := cg.raw[name]; ok {
 		return v, nil, true
 	}
 	if cmsg, ok := cg.cmsgs[name]; ok {
 		return nil, cmsg, true
 	}
-	if cg.cmsg != nil {
-		return nil, cg.cmsg, true
+	// 整体取消可能发生在father上（例如Sudu.Abort），clone同样需要看到
+	for g := cg; g != nil; g = g.father {
+		if g.cmsg != nil {
+			return nil, g.cmsg, true
+		}
 	}
 	return nil, nil, false
 }
@@ -124,11 +285,15 @@ func (cg *ConditionGroup) Inspect(name interface{}) (interface{}, *CancelMessage
 	cg.lock.Lock()
 	defer cg.lock.Unlock()
 
-	return cg.inspect(name)
+	return cg.inspect(cg.key(name))
 }
 
 // raw map first, otherwise waiting for Satisfy()
 func (cg *ConditionGroup) Want(name interface{}) (interface{}, *CancelMessage) {
+	return cg.want(cg.key(name))
+}
+
+func (cg *ConditionGroup) want(name interface{}) (interface{}, *CancelMessage) {
 	cg.lock.Lock()
 	if v, cmsg, ok := cg.inspect(name); ok {
 		cg.emitReadEvent(name)
@@ -136,15 +301,107 @@ func (cg *ConditionGroup) Want(name interface{}) (interface{}, *CancelMessage) {
 		return v, cmsg
 	}
 
+	lsn := cg.listen(name)
+	cg.lock.Unlock()
+
+	cg.emitParkEvent(true)
+	<-lsn
+	cg.emitParkEvent(false)
+	return cg.want(name)
+}
+
+// 等待全部条件，values和cmsgs按names的顺序一一对应
+// 与依次Want不同，只在全部条件都就绪后才会被唤醒一次
+func (cg *ConditionGroup) WantAll(names ...interface{}) ([]interface{}, []*CancelMessage) {
+	_, values, cmsgs := cg.wantN(len(names), cg.keys(names))
+	return values, cmsgs
+}
+
+// 等待任意一个条件，返回最先就绪的条件名，同时就绪时按names的顺序优先
+func (cg *ConditionGroup) WantAny(names ...interface{}) (interface{}, interface{}, *CancelMessage) {
+	idx, values, cmsgs := cg.wantN(1, cg.keys(names))
+	if len(idx) == 0 {
+		return nil, nil, nil
+	}
+	return names[idx[0]], values[0], cmsgs[0]
+}
+
+// 等待任意n个条件，返回已就绪的条件名，以及对应的值
+func (cg *ConditionGroup) WantN(n int, names ...interface{}) ([]interface{}, []interface{}, []*CancelMessage) {
+	idx, values, cmsgs := cg.wantN(n, cg.keys(names))
+	got := make([]interface{}, 0, len(idx))
+	for _, i := range idx {
+		got = append(got, names[i])
+	}
+	return got, values, cmsgs
+}
+
+// 只有最终选中的条件才会触发read event，未选中的条件不影响任务的重做
+func (cg *ConditionGroup) wantN(n int, names []interface{}) ([]int, []interface{}, []*CancelMessage) {
+	if n > len(names) {
+		n = len(names)
+	} else if n < 0 {
+		n = 0
+	}
+
+	for {
+		cg.lock.Lock()
+		idx := make([]int, 0, len(names))
+		lsns := make([]chan struct{}, 0)
+		for i, name := range names {
+			if _, _, ok := cg.inspect(name); ok {
+				idx = append(idx, i)
+			} else {
+				lsns = append(lsns, cg.listen(name))
+			}
+		}
+
+		if len(idx) >= n {
+			idx = idx[:n]
+			values := make([]interface{}, 0, n)
+			cmsgs := make([]*CancelMessage, 0, n)
+			for _, i := range idx {
+				v, cmsg, _ := cg.inspect(names[i])
+				cg.emitReadEvent(names[i])
+				values = append(values, v)
+				cmsgs = append(cmsgs, cmsg)
+			}
+			cg.lock.Unlock()
+			return idx, values, cmsgs
+		}
+		cg.lock.Unlock()
+
+		cg.emitParkEvent(true)
+		if n == len(names) {
+			for _, lsn := range lsns {
+				<-lsn
+			}
+		} else {
+			waitAny(lsns)
+		}
+		cg.emitParkEvent(false)
+	}
+}
+
+// 调用方需持有锁
+func (cg *ConditionGroup) listen(name interface{}) chan struct{} {
 	lsn, ok := cg.lsn[name]
 	if !ok {
 		lsn = make(chan struct{}, 0)
 		cg.lsn[name] = lsn
 	}
-	cg.lock.Unlock()
+	return lsn
+}
 
-	<-lsn
-	return cg.Want(name)
+func waitAny(lsns []chan struct{}) {
+	cases := make([]reflect.SelectCase, 0, len(lsns))
+	for _, lsn := range lsns {
+		cases = append(cases, reflect.SelectCase{
+			Dir:  reflect.SelectRecv,
+			Chan: reflect.ValueOf(lsn),
+		})
+	}
+	reflect.Select(cases)
 }
 
 // got or panic
@@ -159,6 +416,11 @@ func (cg *ConditionGroup) Require(name interface{}) interface{} {
 // fill raw map or cancel it, then wakeup listeners
 // name, value, [name, value, ...]
 func (cg *ConditionGroup) satisfy(canceled, legacy bool, nvs ...interface{}) {
+	cg.write(canceled, legacy, false, nvs...)
+}
+
+// stale为true时，写入的条件被标记为过期的，否则清除标记
+func (cg *ConditionGroup) write(canceled, legacy, stale bool, nvs ...interface{}) {
 	if len(nvs)%2 == 1 {
 		nvs = append(nvs, nil)
 	}
@@ -178,13 +440,25 @@ func (cg *ConditionGroup) satisfy(canceled, legacy bool, nvs ...interface{}) {
 		} else {
 			cg.legacy[name] = false
 		}
+		if stale {
+			cg.stale[name] = true
+		} else {
+			delete(cg.stale, name)
+		}
 
 		if canceled {
 			delete(cg.raw, name)
-			cg.cmsgs[name] = &CancelMessage{value}
+			// 已经是此条件的取消信息（例如legacy翻转）时原样保留，避免重复包装
+			if cmsg, ok := value.(*CancelMessage); ok && cmsg != nil && cmsg.Name == name {
+				cg.cmsgs[name] = cmsg
+			} else {
+				cg.cmsgs[name] = newCancelMessage(name, value)
+			}
+			cg.record(name, nil, cg.cmsgs[name], cg.legacy[name], false)
 		} else {
 			delete(cg.cmsgs, name)
 			cg.raw[name] = value
+			cg.record(name, value, nil, cg.legacy[name], false)
 		}
 		if lsn, ok := cg.lsn[name]; ok {
 			close(lsn) // wakeup
@@ -197,18 +471,47 @@ func (cg *ConditionGroup) satisfy(canceled, legacy bool, nvs ...interface{}) {
 }
 
 // name, value, [name, value, ...]
+// 未通过校验的条件会被取消；在任务中调用时，之后panic(*ValidationError)使任务失败
+// 任务之外的调用不会panic，需要得知校验结果时使用TrySatisfy
+// 只有Sudu的任务会被识别，ConditionTask的任务同样不会panic，参见ConditionTask
 func (cg *ConditionGroup) Satisfy(nvs ...interface{}) {
+	if err := cg.TrySatisfy(nvs...); err != nil && cg.writer >= 0 {
+		panic(err)
+	}
+}
+
+// 与Satisfy相同，但总是以返回值报告第一个校验错误
+func (cg *ConditionGroup) TrySatisfy(nvs ...interface{}) error {
+	nvs = cg.writable(nvs, 2)
+	if cg.buffer != nil {
+		cg.lock.Lock()
+		cg.buffer.write(false, nvs)
+		cg.lock.Unlock()
+		return nil
+	}
+	return cg.satisfyValid(cg.legacyMode(), nvs...)
+}
+
+// 未通过校验的条件会被取消，返回第一个校验错误
+func (cg *ConditionGroup) satisfyValid(legacy bool, nvs ...interface{}) error {
 	cg.lock.Lock()
 	defer cg.lock.Unlock()
 
-	cg.satisfy(false, cg.legacy_mode, nvs...)
+	valid, invalid, err := cg.validate(nvs)
+	cg.satisfy(false, legacy, valid...)
+	if len(invalid) > 0 {
+		cg.satisfy(true, legacy, invalid...)
+	}
+	return err
 }
 
+// 还原的条件未通过校验时直接丢弃
 func (cg *ConditionGroup) satisfyLegacy(nvs ...interface{}) {
 	cg.lock.Lock()
 	defer cg.lock.Unlock()
 
-	cg.satisfy(false, true, nvs...)
+	valid, _, _ := cg.validate(nvs)
+	cg.satisfy(false, true, valid...)
 }
 
 // name, msg, [name, msg, ...]
@@ -216,19 +519,57 @@ func (cg *ConditionGroup) Cancel(nvs ...interface{}) {
 	cg.lock.Lock()
 	defer cg.lock.Unlock()
 
-	cg.satisfy(true, cg.legacy_mode, nvs...)
+	if cg.buffer != nil {
+		cg.buffer.write(true, cg.writable(nvs, 2))
+		return
+	}
+	cg.satisfy(true, cg.legacyMode(), cg.writable(nvs, 2)...)
+}
+
+// 将条件恢复为未知状态，值以及取消信息均被移除
+// 之后的Want将重新阻塞，直到条件再次被满足或取消
+func (cg *ConditionGroup) Retract(names ...interface{}) {
+	names = cg.writable(names, 1)
+
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	if cg.buffer != nil {
+		cg.buffer.retract(names)
+		return
+	}
+	cg.retract(names...)
+}
+
+func (cg *ConditionGroup) retract(names ...interface{}) {
+	for _, name := range names {
+		delete(cg.raw, name)
+		delete(cg.cmsgs, name)
+		delete(cg.legacy, name)
+		delete(cg.stale, name)
+		cg.record(name, nil, nil, false, true)
+	}
+
+	cg.emitWriteEvent(names...)
+}
+
+// 取消全部尚未满足的条件，唤醒所有的等待者
+// 之后对未知条件的Want都将立即返回取消信息，已经满足的条件不受影响
+func (cg *ConditionGroup) CancelAll(reason interface{}) {
+	cg.base().cancelAll(newCancelMessage(nil, reason))
 }
 
-func (cg *ConditionGroup) cancelAll(msg interface{}) {
+func (cg *ConditionGroup) cancelAll(cmsg *CancelMessage) {
 	cg.lock.Lock()
 	defer cg.lock.Unlock()
 
-	cg.cmsg = &CancelMessage{msg}
+	cg.cmsg = cmsg
 
 	names := make([]interface{}, 0, len(cg.lsn))
 	for name, lsn := range cg.lsn {
 		close(lsn) // wakeup
 		delete(cg.lsn, name)
+		cg.record(name, nil, cg.cmsg, false, false)
 		names = append(names, name)
 	}
 
@@ -50,3 +50,92 @@ func TestConditionGroup(t *testing.T) {
 		t.Errorf("in = %d, out = %d, expect = %d", a, x, 2*a*3*a*4*3*a)
 	}
 }
+
+func TestConditionGroupRetract(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.Satisfy("A", 1)
+	cg.Retract("A")
+
+	if _, _, ok := cg.Inspect("A"); ok {
+		t.Fatalf("expect A retracted")
+	}
+
+	got := make(chan int)
+	go func() {
+		got <- cg.Require("A").(int)
+	}()
+
+	select {
+	case <-got:
+		t.Fatalf("expect Want blocked after Retract")
+	case <-time.After(time.Millisecond):
+	}
+
+	cg.Satisfy("A", 2)
+	if a := <-got; a != 2 {
+		t.Errorf("A = %d, expect %d", a, 2)
+	}
+}
+
+func TestConditionGroupWantMulti(t *testing.T) {
+	cg := NewConditionGroup()
+
+	all := make(chan []interface{})
+	go func() {
+		values, _ := cg.WantAll("A", "B")
+		all <- values
+	}()
+
+	first := make(chan interface{})
+	go func() {
+		name, _, _ := cg.WantAny("B", "C")
+		first <- name
+	}()
+
+	cg.Satisfy("A", 1)
+	cg.Cancel("C", "gone")
+	if name := <-first; name != "C" {
+		t.Errorf("WantAny = %v, expect %v", name, "C")
+	}
+
+	select {
+	case <-all:
+		t.Fatalf("expect WantAll blocked until B")
+	case <-time.After(time.Millisecond):
+	}
+	cg.Satisfy("B", 2)
+	if values := <-all; values[0] != 1 || values[1] != 2 {
+		t.Errorf("WantAll = %v, expect [1 2]", values)
+	}
+
+	names, values, cmsgs := cg.WantN(2, "X", "B", "C", "A")
+	if len(names) != 2 || names[0] != "B" || names[1] != "C" {
+		t.Errorf("WantN = %v, expect [B C]", names)
+	}
+	if values[0] != 2 || cmsgs[0] != nil || cmsgs[1] == nil {
+		t.Errorf("WantN values = %v, cmsgs = %v", values, cmsgs)
+	}
+}
+
+func TestConditionGroupCancelAll(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.Satisfy("A", 1)
+
+	woken := make(chan *CancelMessage)
+	go func() {
+		_, cmsg := cg.Want("B")
+		woken <- cmsg
+	}()
+
+	time.Sleep(time.Millisecond)
+	cg.CancelAll("stop")
+	if cmsg := <-woken; cmsg == nil || cmsg.Message != "stop" {
+		t.Errorf("Want = %v, expect canceled", cmsg)
+	}
+	if _, cmsg := cg.Want("C"); cmsg == nil {
+		t.Errorf("expect C canceled")
+	}
+	if v, cmsg := cg.Want("A"); v != 1 || cmsg != nil {
+		t.Errorf("A = %v, %v, expect untouched", v, cmsg)
+	}
+}
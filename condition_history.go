@@ -0,0 +1,80 @@
+package sudu
+
+import (
+	"time"
+)
+
+// 条件的一次写入记录
+type ConditionRecord struct {
+	Version   uint64
+	Value     interface{}
+	Cancel    *CancelMessage
+	Legacy    bool
+	Retracted bool
+	// 以过期的值降级确认，参见Sudu.Fallback
+	Stale bool
+	// 写入该条件的Sudu任务id，不是由任务写入时为-1
+	Task int
+	Time time.Time
+}
+
+// 每个条件最多保留size条写入历史，默认为0，即不记录历史
+// 只影响之后的写入，应当在开始写入之前设置
+func (cg *ConditionGroup) SetHistory(size int) {
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	cg.history_size = size
+}
+
+// 调用方需持有锁
+func (cg *ConditionGroup) record(name, value interface{}, cmsg *CancelMessage, legacy, retracted bool) {
+	cg.versions[name]++
+	if cg.history_size <= 0 {
+		return
+	}
+
+	h := append(cg.history[name], ConditionRecord{
+		Version:   cg.versions[name],
+		Value:     value,
+		Cancel:    cmsg,
+		Legacy:    legacy,
+		Stale:     cg.stale[name],
+		Retracted: retracted,
+		Task:      cg.writer,
+		Time:      time.Now(),
+	})
+	if len(h) > cg.history_size {
+		h = h[len(h)-cg.history_size:]
+	}
+	cg.history[name] = h
+}
+
+// 条件是否以过期的值降级确认，参见Sudu.Fallback
+func (cg *ConditionGroup) Stale(name interface{}) bool {
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	return cg.stale[cg.key(name)]
+}
+
+// 同Inspect，额外返回条件当前的版本号，从未写入过的条件版本号为0
+func (cg *ConditionGroup) InspectVersion(name interface{}) (interface{}, *CancelMessage, uint64, bool) {
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	name = cg.key(name)
+	v, cmsg, ok := cg.inspect(name)
+	return v, cmsg, cg.versions[name], ok
+}
+
+// 条件的写入历史，按版本从旧到新
+func (cg *ConditionGroup) History(name interface{}) []ConditionRecord {
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	name = cg.key(name)
+	h := make([]ConditionRecord, len(cg.history[name]))
+	copy(h, cg.history[name])
+	return h
+}
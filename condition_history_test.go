@@ -0,0 +1,51 @@
+package sudu
+
+import (
+	"testing"
+)
+
+func TestConditionHistory(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.SetHistory(2)
+
+	cg.Satisfy("A", 1)
+	cg.Satisfy("A", 2)
+	cg.Cancel("A", "stop")
+
+	v, cmsg, version, ok := cg.InspectVersion("A")
+	if v != nil || cmsg == nil || version != 3 || ok == false {
+		t.Errorf("inspect = %v, %v, %d, %v", v, cmsg, version, ok)
+	}
+
+	h := cg.History("A")
+	if len(h) != 2 || h[0].Version != 2 || h[0].Value != 2 || h[1].Cancel == nil || h[1].Task != -1 {
+		t.Errorf("history = %+v", h)
+	}
+
+	if _, _, version, _ := cg.InspectVersion("B"); version != 0 {
+		t.Errorf("B version = %d, expect %d", version, 0)
+	}
+}
+
+func TestConditionHistorySudu(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.SetHistory(10)
+
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("B", cg.Require("A").(int)*2)
+	})
+	sd.SatisfyLegacy("B", 4)
+	sd.Satisfy("A", 2)
+	sd.Wait()
+
+	h := sd.History("B")
+	if len(h) != 2 {
+		t.Fatalf("history = %+v, expect 2 records", h)
+	}
+	if h[0].Legacy == false || h[0].Task != -1 {
+		t.Errorf("first record = %+v, expect legacy restore", h[0])
+	}
+	if h[1].Legacy || h[1].Task != 0 || h[1].Value != 4 || h[1].Version != 2 {
+		t.Errorf("second record = %+v, expect confirmed by task 0", h[1])
+	}
+}
@@ -0,0 +1,159 @@
+package sudu
+
+import (
+	"fmt"
+	"sync"
+)
+
+// 作用域内的条件，在根group中实际使用的条件名
+type ScopedName struct {
+	Scope string
+	Name  interface{}
+}
+
+// 作用域的声明，同一路径的作用域在group（以及它的clone）之间共享
+type conditionScope struct {
+	path   string
+	parent *conditionScope
+
+	lock    sync.RWMutex
+	imports map[interface{}]bool
+	exports map[interface{}]bool
+}
+
+// 返回一个命名空间隔离的视图，视图上的读写都只作用于prefix作用域内的条件
+// 在视图上继续调用Scope则得到嵌套的作用域，路径以/分隔
+// 与上一级作用域之间的条件共享，需要通过Import和Export显式声明
+func (cg *ConditionGroup) Scope(prefix string) *ConditionGroup {
+	path := prefix
+	if cg.scope != nil {
+		path = cg.scope.path + "/" + prefix
+	}
+
+	cg.lock.Lock()
+	sc := cg.scopes[path]
+	if sc == nil {
+		sc = &conditionScope{
+			path:    path,
+			parent:  cg.scope,
+			imports: make(map[interface{}]bool),
+			exports: make(map[interface{}]bool),
+		}
+		cg.scopes[path] = sc
+	}
+	cg.lock.Unlock()
+
+	view := cg.child()
+	view.view = true
+	view.scope = sc
+	return view
+}
+
+// 作用域内读取names时使用上一级作用域的同名条件，作用域内不允许写入
+func (cg *ConditionGroup) Import(names ...interface{}) {
+	if sc := cg.scope; sc != nil {
+		sc.lock.Lock()
+		defer sc.lock.Unlock()
+		for _, name := range names {
+			sc.imports[name] = true
+		}
+	}
+}
+
+// 作用域内对names的读写都直接作用于上一级作用域的同名条件
+func (cg *ConditionGroup) Export(names ...interface{}) {
+	if sc := cg.scope; sc != nil {
+		sc.lock.Lock()
+		defer sc.lock.Unlock()
+		for _, name := range names {
+			sc.exports[name] = true
+		}
+	}
+}
+
+// 视图本身不持有任何监听，监听需要注册到实际的group上
+func (cg *ConditionGroup) base() *ConditionGroup {
+	for cg.view {
+		cg = cg.father
+	}
+	return cg
+}
+
+// 视图的legacy状态跟随实际的group（例如Sudu任务的clone）
+func (cg *ConditionGroup) legacyMode() bool {
+	return cg.base().legacy_mode
+}
+
+func (sc *conditionScope) key(name interface{}) interface{} {
+	if sc == nil {
+		return name
+	}
+
+	sc.lock.RLock()
+	linked := sc.imports[name] || sc.exports[name]
+	sc.lock.RUnlock()
+
+	if linked {
+		return sc.parent.key(name)
+	}
+	return ScopedName{sc.path, name}
+}
+
+// key的逆运算，不属于此作用域的条件返回false
+func (sc *conditionScope) local(key interface{}) (interface{}, bool) {
+	if sc == nil {
+		return key, true
+	}
+	if sn, ok := key.(ScopedName); ok && sn.Scope == sc.path {
+		return sn.Name, true
+	}
+
+	sc.lock.RLock()
+	defer sc.lock.RUnlock()
+	for _, links := range []map[interface{}]bool{sc.imports, sc.exports} {
+		for name := range links {
+			if sc.parent.key(name) == key {
+				return name, true
+			}
+		}
+	}
+	return nil, false
+}
+
+func (cg *ConditionGroup) key(name interface{}) interface{} {
+	return cg.scope.key(name)
+}
+
+func (cg *ConditionGroup) keys(names []interface{}) []interface{} {
+	if cg.scope == nil {
+		return names
+	}
+
+	keys := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		keys = append(keys, cg.key(name))
+	}
+	return keys
+}
+
+// 用于写入，只转换name, value, [name, value, ...]中的name
+// 写入导入的条件会panic
+func (cg *ConditionGroup) writable(nvs []interface{}, step int) []interface{} {
+	sc := cg.scope
+	if sc == nil {
+		return nvs
+	}
+
+	kvs := make([]interface{}, len(nvs))
+	copy(kvs, nvs)
+	for i := 0; i < len(kvs); i += step {
+		sc.lock.RLock()
+		imported := sc.imports[kvs[i]]
+		sc.lock.RUnlock()
+		if imported {
+			panic(fmt.Errorf("condition %v is imported by scope %s, read only", kvs[i], sc.path))
+		}
+		kvs[i] = cg.key(kvs[i])
+	}
+	return kvs
+}
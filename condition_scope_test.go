@@ -0,0 +1,85 @@
+package sudu
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConditionScope(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.SatisfyLegacy(ScopedName{"m1", "B"}, 2)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := sd.Scope("m1").Watch(ctx, "C")
+
+	module := func(prefix string, k int) {
+		m := sd.Scope(prefix)
+		m.Import("A")
+		m.Export("D")
+
+		sd.Go(func(cg *ConditionGroup) {
+			cg = cg.Scope(prefix)
+			cg.Satisfy("B", cg.Require("A").(int)*k)
+		})
+		sd.Go(func(cg *ConditionGroup) {
+			cg = cg.Scope(prefix)
+			cg.Satisfy("C", cg.Require("B").(int)+1)
+			if k == 2 {
+				cg.Satisfy("D", k)
+			}
+		})
+	}
+	module("m1", 2)
+	module("m2", 3)
+
+	time.Sleep(time.Millisecond)
+	sd.Satisfy("A", 10)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if c := sd.Require(ScopedName{"m1", "C"}).(int); c != 21 {
+		t.Errorf("m1 C = %d, expect %d", c, 21)
+	}
+	if c := sd.Scope("m2").Require("C").(int); c != 31 {
+		t.Errorf("m2 C = %d, expect %d", c, 31)
+	}
+	if d := sd.Require("D").(int); d != 2 {
+		t.Errorf("D = %d, expect %d", d, 2)
+	}
+	if _, _, ok := sd.Inspect("B"); ok {
+		t.Errorf("expect B invisible in root")
+	}
+	if len(sd.Unconfirmed()) != 0 {
+		t.Errorf("expect all confirmed, got %v", sd.Unconfirmed())
+	}
+
+	ev := <-events
+	if ev.Name != "C" || ev.Value != 3 || ev.Legacy == false {
+		t.Errorf("event = %+v, expect legacy C", ev)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expect panic writing imported condition")
+		}
+	}()
+	sd.Scope("m1").Satisfy("A", 1)
+}
+
+func TestConditionScopeNested(t *testing.T) {
+	cg := NewConditionGroup()
+	inner := cg.Scope("a").Scope("b")
+	inner.Export("X")
+	cg.Scope("a").Export("X")
+
+	inner.Satisfy("X", 1, "Y", 2)
+	if x := cg.Require("X").(int); x != 1 {
+		t.Errorf("X = %d, expect %d", x, 1)
+	}
+	if y := cg.Require(ScopedName{"a/b", "Y"}).(int); y != 2 {
+		t.Errorf("Y = %d, expect %d", y, 2)
+	}
+}
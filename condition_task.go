@@ -5,6 +5,8 @@ package sudu
 // 不涉及条件的legacy状态变更问题
 // 所有条件只区分有和没有
 // 可以尽最大努力在满足条件的前提下进行任务并发
+// 任务与调用方共用同一个group，Satisfy无法区分是否在任务中调用，校验失败时只取消条件而不会panic
+// 需要校验失败使任务失败时，任务应当使用TrySatisfy，并自行panic返回的错误
 type ConditionTask struct {
 	ConditionGroup
 	TaskGroup
@@ -12,15 +14,23 @@ type ConditionTask struct {
 
 func NewConditionTask() *ConditionTask {
 	cg := NewConditionGroup()
-	tg := NewTaskGroup()
 
-	tg.panicFilter = func(p interface{}) interface{} {
-		cg.cancelAll(p)
-		return p
+	// TaskGroup直接在ct中初始化，避免复制其中的锁
+	ct := &ConditionTask{
+		ConditionGroup: *cg,
 	}
+	ct.TaskGroup.init()
 
-	return &ConditionTask{
-		*cg,
-		*tg,
+	// 取消的必须是ct中的group，任务通过ct读取条件，看不到cg上的整体取消
+	ct.panicFilter = func(p interface{}) interface{} {
+		ct.ConditionGroup.cancelAll(newCancelMessage(nil, p))
+		return p
 	}
+	return ct
+}
+
+// 取消所有尚未满足的条件，唤醒阻塞在Want中的任务，之后等待所有任务退出
+func (ct *ConditionTask) Close() {
+	ct.ConditionGroup.CancelAll("closed")
+	ct.Drain()
 }
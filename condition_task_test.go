@@ -1,6 +1,8 @@
 package sudu
 
 import (
+	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -50,3 +52,49 @@ func TestConditionTask(t *testing.T) {
 		t.Errorf("in = %d, out = %d, expect = %d", a, x, 2*a*3*a*4*3*a)
 	}
 }
+
+func TestConditionTaskClose(t *testing.T) {
+	ct := NewConditionTask()
+
+	var exited int32
+	ct.Go(func() {
+		defer atomic.AddInt32(&exited, 1)
+		ct.Want("X")
+	})
+	ct.Go(func() {
+		panic(fmt.Errorf("fail"))
+	})
+
+	if err := ct.Wait(); err == nil {
+		t.Fatalf("expect error")
+	}
+	ct.Close()
+	if n := atomic.LoadInt32(&exited); n != 1 {
+		t.Errorf("exited = %d, expect parked task to exit before Close returns", n)
+	}
+}
+
+func TestConditionTaskCancelOnPanic(t *testing.T) {
+	ct := NewConditionTask()
+
+	woken := make(chan *CancelMessage, 1)
+	ct.Go(func() {
+		_, cmsg := ct.Want("X")
+		woken <- cmsg
+	})
+	ct.Go(func() {
+		time.Sleep(time.Millisecond)
+		panic(fmt.Errorf("fail"))
+	})
+	ct.Wait()
+
+	select {
+	case cmsg := <-woken:
+		if cmsg == nil {
+			t.Errorf("expect X canceled")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expect waiters woken by the failed task")
+	}
+	ct.Close()
+}
@@ -0,0 +1,82 @@
+package sudu
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// 条件的值未通过校验，同时也是被取消的条件的CancelMessage
+type ValidationError struct {
+	Name  interface{}
+	Value interface{}
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("condition %v: invalid value %#v: %v", e.Name, e.Value, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// 注册条件的校验方法，Satisfy时按注册的顺序执行
+// 校验失败的条件会被取消，CancelMessage为*ValidationError
+// 同时任务中的Satisfy会panic同样的错误，从而使写入该条件的任务失败
+func (cg *ConditionGroup) Validate(name interface{}, fx func(value interface{}) error) {
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	name = cg.key(name)
+	cg.validators[name] = append(cg.validators[name], fx)
+}
+
+// 要求条件的值与sample的类型完全一致
+func (cg *ConditionGroup) ValidateType(name, sample interface{}) {
+	typ := reflect.TypeOf(sample)
+	cg.Validate(name, func(value interface{}) error {
+		if t := reflect.TypeOf(value); t != typ {
+			return fmt.Errorf("type %v, expect %v", t, typ)
+		}
+		return nil
+	})
+}
+
+// 调用方需持有锁
+func (cg *ConditionGroup) check(name, value interface{}) *ValidationError {
+	for _, fx := range cg.validators[name] {
+		if err := fx(value); err != nil {
+			return &ValidationError{
+				Name:  name,
+				Value: value,
+				Err:   err,
+			}
+		}
+	}
+	return nil
+}
+
+// 将nvs分为通过校验的，以及未通过校验的(name, *ValidationError)，同时返回第一个校验错误
+func (cg *ConditionGroup) validate(nvs []interface{}) ([]interface{}, []interface{}, error) {
+	if len(cg.validators) == 0 {
+		return nvs, nil, nil
+	}
+	if len(nvs)%2 == 1 {
+		nvs = append(nvs, nil)
+	}
+
+	var first error
+	valid := make([]interface{}, 0, len(nvs))
+	invalid := make([]interface{}, 0)
+	for i := 0; i < len(nvs); i += 2 {
+		if err := cg.check(nvs[i], nvs[i+1]); err != nil {
+			invalid = append(invalid, nvs[i], err)
+			if first == nil {
+				first = err
+			}
+		} else {
+			valid = append(valid, nvs[i], nvs[i+1])
+		}
+	}
+	return valid, invalid, first
+}
@@ -0,0 +1,86 @@
+package sudu
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConditionValidate(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.ValidateType("A", 0)
+	cg.Validate("A", func(v interface{}) error {
+		if v.(int) < 0 {
+			return fmt.Errorf("negative")
+		}
+		return nil
+	})
+
+	cg.Satisfy("A", 1)
+	if a := cg.Require("A").(int); a != 1 {
+		t.Errorf("A = %d, expect %d", a, 1)
+	}
+
+	for _, v := range []interface{}{"1", -1} {
+		var verr *ValidationError
+		if err := cg.TrySatisfy("A", v, "B", v); errors.As(err, &verr) == false {
+			t.Errorf("expect validation error for %#v, got %v", v, err)
+		}
+
+		_, cmsg := cg.Want("A")
+		if cmsg == nil {
+			t.Errorf("expect A canceled for %#v", v)
+		} else if _, ok := cmsg.Message.(*ValidationError); ok == false {
+			t.Errorf("cancel message = %#v", cmsg.Message)
+		}
+		if b := cg.Require("B"); b != v {
+			t.Errorf("B = %v, expect %v", b, v)
+		}
+	}
+
+	// 任务之外的Satisfy不会panic
+	cg.Satisfy("A", -2)
+	if _, cmsg := cg.Want("A"); cmsg == nil {
+		t.Errorf("expect A canceled")
+	}
+}
+
+func TestConditionValidateSudu(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.ValidateType("B", "")
+
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("B", cg.Require("A"))
+	})
+
+	// 还原的条件未通过校验时被丢弃
+	sd.SatisfyLegacy("B", 1)
+	if _, _, ok := sd.Inspect("B"); ok {
+		t.Errorf("expect invalid legacy B dropped")
+	}
+
+	sd.Satisfy("A", 1)
+	var verr *ValidationError
+	if err := sd.Wait(); errors.As(err, &verr) == false || verr.Name != "B" {
+		t.Errorf("Wait = %v, expect validation error", err)
+	}
+}
+
+func TestConditionValidateTask(t *testing.T) {
+	ct := NewConditionTask()
+	ct.ValidateType("B", 0)
+
+	ct.Go(func() {
+		if err := ct.TrySatisfy("B", ct.Require("A")); err != nil {
+			panic(err)
+		}
+	})
+
+	// ConditionTask中的Satisfy不会panic，任务需要自行处理TrySatisfy的结果
+	ct.Satisfy("A", "a")
+	var verr *ValidationError
+	if err := ct.Wait(); errors.As(err, &verr) == false || verr.Name != "B" {
+		t.Errorf("Wait = %v, expect validation error", err)
+	}
+	ct.Close()
+}
@@ -0,0 +1,142 @@
+package sudu
+
+import (
+	"context"
+	"sync"
+)
+
+// 条件的一次变更
+type ConditionEvent struct {
+	Name interface{}
+	// 变更之前的值，以订阅时刻的值为起点
+	Old    interface{}
+	Value  interface{}
+	Cancel *CancelMessage
+	Legacy bool
+	// 以过期的值降级确认，参见Sudu.Fallback
+	Stale bool
+	// 条件被Retract，恢复为未知状态
+	Retracted bool
+	Version   uint64
+}
+
+// 订阅条件的变更，事件按照写入的顺序投递，names为空时订阅全部条件
+// 写入发生在此group或其clone上（例如Sudu的任务）才能被观察到
+// ctx结束后停止订阅并关闭返回的channel
+// 在Scope视图上订阅时，只能观察到该作用域内的条件，事件中的条件名也是作用域内的名字
+func (cg *ConditionGroup) Watch(ctx context.Context, names ...interface{}) <-chan ConditionEvent {
+	keys := cg.keys(names)
+	var filter map[interface{}]bool
+	if len(keys) > 0 {
+		filter = make(map[interface{}]bool, len(keys))
+		for _, key := range keys {
+			filter[key] = true
+		}
+	}
+	sc := cg.scope
+	cg = cg.base()
+
+	q := newQueue()
+	last := make(map[interface{}]interface{})
+
+	cg.lock.Lock()
+	if filter == nil {
+		keys = cg.conditions()
+	}
+	for _, key := range keys {
+		last[key], _, _ = cg.inspect(key)
+	}
+	unlisten := cg.listenWriteEvent(func(keys ...interface{}) {
+		for _, key := range keys {
+			if filter != nil && filter[key] == false {
+				continue
+			}
+			name, ok := sc.local(key)
+			if ok == false {
+				continue
+			}
+			value, cmsg, ok := cg.inspect(key)
+			q.push(ConditionEvent{
+				Name:      name,
+				Old:       last[key],
+				Value:     value,
+				Cancel:    cmsg,
+				Legacy:    cg.legacy[key],
+				Stale:     cg.stale[key],
+				Retracted: ok == false,
+				Version:   cg.versions[key],
+			})
+			last[key] = value
+		}
+	})
+	cg.lock.Unlock()
+
+	out := make(chan ConditionEvent)
+	go func() {
+		defer close(out)
+		defer func() {
+			cg.lock.Lock()
+			unlisten()
+			cg.lock.Unlock()
+		}()
+
+		for {
+			evs, ok := q.take(ctx)
+			if ok == false {
+				return
+			}
+			for _, ev := range evs {
+				select {
+				case out <- ev.(ConditionEvent):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// 无界的事件队列，写入方（通常持有锁）永远不会被阻塞
+type queue struct {
+	lock   sync.Mutex
+	items  []interface{}
+	signal chan struct{}
+}
+
+func newQueue() *queue {
+	return &queue{
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (q *queue) push(item interface{}) {
+	q.lock.Lock()
+	q.items = append(q.items, item)
+	q.lock.Unlock()
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+}
+
+// 取出当前全部的事件，队列为空时阻塞，ctx结束时返回false
+func (q *queue) take(ctx context.Context) ([]interface{}, bool) {
+	for {
+		q.lock.Lock()
+		if len(q.items) > 0 {
+			items := q.items
+			q.items = nil
+			q.lock.Unlock()
+			return items, true
+		}
+		q.lock.Unlock()
+
+		select {
+		case <-q.signal:
+		case <-ctx.Done():
+			return nil, false
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package sudu
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConditionWatch(t *testing.T) {
+	cg := NewConditionGroup()
+	cg.Satisfy("A", 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := cg.Watch(ctx, "A")
+
+	cg.Satisfy("A", 1, "B", 1)
+	cg.Satisfy("A", 2)
+	cg.Cancel("A", "stop")
+	cg.Retract("A")
+
+	expect := []ConditionEvent{
+		{Name: "A", Old: 0, Value: 1},
+		{Name: "A", Old: 1, Value: 2},
+		{Name: "A", Old: 2},
+		{Name: "A", Retracted: true},
+	}
+	for i, e := range expect {
+		ev := <-events
+		if ev.Name != e.Name || ev.Old != e.Old || ev.Value != e.Value || ev.Retracted != e.Retracted {
+			t.Errorf("event %d = %+v, expect %+v", i, ev, e)
+		}
+		if i == 2 && (ev.Cancel == nil || ev.Cancel.Message != "stop") {
+			t.Errorf("event %d cancel = %v, expect stop", i, ev.Cancel)
+		}
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestConditionWatchSudu(t *testing.T) {
+	sd := NewSudu(nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events := sd.Watch(ctx)
+
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("B", cg.Require("A").(int)*2)
+	})
+	sd.SatisfyLegacy("B", 1)
+	sd.Satisfy("A", 1)
+	sd.Wait()
+
+	expect := []ConditionEvent{
+		{Name: "B", Value: 1, Legacy: true},
+		{Name: "A", Value: 1},
+		{Name: "B", Old: 1, Value: 2},
+	}
+	for i, e := range expect {
+		ev := <-events
+		if ev.Name != e.Name || ev.Old != e.Old || ev.Value != e.Value || ev.Legacy != e.Legacy {
+			t.Errorf("event %d = %+v, expect %+v", i, ev, e)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package sudu
+
+import (
+	"context"
+)
+
+// 与golang.org/x/sync/errgroup语义一致的TaskGroup
+// 任务返回的第一个error作为Wait的结果，同时取消WithContext返回的ctx
+// Wait总是等待全部任务结束；任务panic的处理与TaskGroup相同，panic(error)等同于返回error
+type ErrGroup struct {
+	*TaskGroup
+
+	gate   *scheduler
+	cancel context.CancelFunc
+}
+
+func WithContext(ctx context.Context) (*ErrGroup, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	eg := &ErrGroup{
+		TaskGroup: NewTaskGroup(),
+		gate:      newScheduler(),
+		cancel:    cancel,
+	}
+	eg.WaitAll = true
+	eg.panicFilter = func(p interface{}) interface{} {
+		cancel()
+		return p
+	}
+	return eg, ctx
+}
+
+// 同时运行的任务数量上限，n <= 0 时不做限制
+// 达到上限时，Go会阻塞直到有任务结束
+func (eg *ErrGroup) SetLimit(n int) {
+	eg.gate.setLimit(n)
+}
+
+func (eg *ErrGroup) Go(fxs ...func() error) {
+	for _, fx := range fxs {
+		eg.gate.acquire(0, 0)
+		eg.TaskGroup.Go(eg.wrap(fx))
+	}
+}
+
+// 达到上限时不启动任务，返回false
+func (eg *ErrGroup) TryGo(fx func() error) bool {
+	if eg.gate.tryAcquire() == false {
+		return false
+	}
+	eg.TaskGroup.Go(eg.wrap(fx))
+	return true
+}
+
+func (eg *ErrGroup) wrap(fx func() error) func() {
+	return func() {
+		defer eg.gate.release()
+		if err := fx(); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// 等待全部任务结束，返回第一个error，之后ctx总是被取消
+func (eg *ErrGroup) Wait() error {
+	defer eg.cancel()
+	return eg.TaskGroup.Wait()
+}
@@ -0,0 +1,64 @@
+package sudu
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestErrGroup(t *testing.T) {
+	eg, ctx := WithContext(context.Background())
+
+	fail := errors.New("fail")
+	var stopped int32
+	eg.Go(func() error {
+		<-ctx.Done()
+		atomic.AddInt32(&stopped, 1)
+		return nil
+	}, func() error {
+		time.Sleep(time.Millisecond)
+		return fail
+	})
+
+	if err := eg.Wait(); err != fail {
+		t.Errorf("Wait = %v, expect %v", err, fail)
+	}
+	if atomic.LoadInt32(&stopped) != 1 {
+		t.Errorf("expect Wait to wait for all tasks")
+	}
+}
+
+func TestErrGroupLimit(t *testing.T) {
+	eg, _ := WithContext(context.Background())
+	eg.SetLimit(1)
+
+	release := make(chan struct{})
+	eg.Go(func() error {
+		<-release
+		return nil
+	})
+	if eg.TryGo(func() error { return nil }) {
+		t.Errorf("expect TryGo to fail when limit reached")
+	}
+	close(release)
+
+	var running, peak int32
+	for i := 0; i < 5; i++ {
+		eg.Go(func() error {
+			if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, n)
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if peak != 1 {
+		t.Errorf("peak = %d, expect %d", peak, 1)
+	}
+}
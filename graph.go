@@ -0,0 +1,133 @@
+package sudu
+
+import (
+	"sync"
+)
+
+// 任务图的定义，与运行分离
+// 定义一次之后，可以用不同的输入以及cache开始任意多次相互独立的运行
+// 每次运行都是一个全新的Sudu，拥有独立的条件、任务状态、统计以及错误
+type Graph struct {
+	lock sync.Mutex
+
+	// 先于cache还原执行的声明，例如校验、派生条件
+	prepares []func(*Sudu)
+	// 任务以及嵌套的子图
+	setups []func(*Sudu)
+
+	WaitAll    bool
+	WaitLegacy bool
+	// 参见Sudu.CriticalPath
+	CriticalPath bool
+	// 非nil时所有运行共享同一组命名资源，否则每次运行各自独立
+	Resources *Resources
+
+	limit     int
+	fallbacks []interface{}
+}
+
+func NewGraph() *Graph {
+	return &Graph{
+		lock: sync.Mutex{},
+	}
+}
+
+func (g *Graph) Go(fxs ...func(*ConditionGroup)) {
+	g.GoWith(TaskOptions{}, fxs...)
+}
+
+// 同一个opts中的RetryPolicy、HedgePolicy被所有运行共享，参见HedgePolicy
+func (g *Graph) GoWith(opts TaskOptions, fxs ...func(*ConditionGroup)) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.setups = append(g.setups, func(sd *Sudu) {
+		sd.GoWith(opts, fxs...)
+	})
+}
+
+// 每次运行同时执行的任务数量上限，参见Sudu.SetLimit
+func (g *Graph) SetLimit(n int) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.limit = n
+}
+
+// 参见Sudu.Fallback
+func (g *Graph) Fallback(names ...interface{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.fallbacks = append(g.fallbacks, names...)
+}
+
+func (g *Graph) Derive(name interface{}, inputs []interface{}, fx func(values ...interface{}) interface{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.prepares = append(g.prepares, func(sd *Sudu) {
+		sd.Derive(name, inputs, fx)
+	})
+}
+
+func (g *Graph) Validate(name interface{}, fx func(value interface{}) error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.prepares = append(g.prepares, func(sd *Sudu) {
+		sd.Validate(name, fx)
+	})
+}
+
+func (g *Graph) ValidateType(name, sample interface{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.prepares = append(g.prepares, func(sd *Sudu) {
+		sd.ValidateType(name, sample)
+	})
+}
+
+// 每次运行时，child都会开始一次新的运行，作为此次运行的一个任务，参见Sudu.Nest
+func (g *Graph) Nest(child *Graph, inputs, outputs []interface{}) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.setups = append(g.setups, func(sd *Sudu) {
+		sd.Nest(child.Run(nil), inputs, outputs)
+	})
+}
+
+// 开始一次新的运行，name, value, [name, value, ...]为此次运行的输入
+func (g *Graph) Run(c *Cache, nvs ...interface{}) *Sudu {
+	g.lock.Lock()
+	prepares := g.prepares
+	setups := g.setups
+	limit := g.limit
+	fallbacks := g.fallbacks
+	g.lock.Unlock()
+
+	sd := newSudu(c, func(sd *Sudu) {
+		sd.WaitAll = g.WaitAll
+		sd.WaitLegacy = g.WaitLegacy
+		sd.CriticalPath = g.CriticalPath
+		if g.Resources != nil {
+			sd.Resources = g.Resources
+		}
+		sd.SetLimit(limit)
+		if len(fallbacks) > 0 {
+			sd.Fallback(fallbacks...)
+		}
+		for _, fx := range prepares {
+			fx(sd)
+		}
+	})
+	for _, fx := range setups {
+		fx(sd)
+	}
+	if len(nvs) > 0 {
+		sd.Satisfy(nvs...)
+	}
+	return sd
+}
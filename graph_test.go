@@ -0,0 +1,88 @@
+package sudu
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGraph(t *testing.T) {
+	child := NewGraph()
+	child.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("Y", cg.Require("X").(int)*10)
+	})
+
+	g := NewGraph()
+	g.ValidateType("X", 0)
+	g.Derive("X", []interface{}{"A"}, func(values ...interface{}) interface{} {
+		return values[0].(int) + 1
+	})
+	g.Nest(child, []interface{}{"X"}, []interface{}{"Y"})
+	g.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("Z", cg.Require("Y").(int)+1)
+	})
+
+	wg := sync.WaitGroup{}
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go func(a int) {
+			defer wg.Done()
+			sd := g.Run(nil, "A", a)
+			if err := sd.Wait(); err != nil {
+				t.Errorf("run %d error %v", a, err)
+			}
+			if z := sd.Require("Z").(int); z != (a+1)*10+1 || sd.Tasks != 2 {
+				t.Errorf("run %d Z = %d, tasks = %d", a, z, sd.Tasks)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// 还原的条件同样经过校验
+	cache := NewCache("graph", "invalid")
+	cache.Set([]interface{}{"X", "x"})
+	sd := g.Run(cache)
+	if _, _, ok := sd.Inspect("X"); ok {
+		t.Errorf("expect invalid X dropped")
+	}
+}
+
+func TestGraphOptions(t *testing.T) {
+	cache := NewCache("graph", fmt.Sprintf("options-%d", time.Now().UnixNano()))
+
+	var running, peak, cnt int32
+	busy := func(cg *ConditionGroup) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}
+
+	g := NewGraph()
+	g.SetLimit(1)
+	g.Fallback("B")
+	g.GoWith(TaskOptions{Memo: true}, func(cg *ConditionGroup) {
+		atomic.AddInt32(&cnt, 1)
+		cg.Satisfy("B", cg.Require("A").(int)*2)
+	})
+	g.Go(busy, busy, busy)
+
+	for i := 0; i < 2; i++ {
+		sd := g.Run(cache, "A", 1)
+		if err := sd.Wait(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		if b := sd.Require("B").(int); b != 2 || sd.Replays != i {
+			t.Errorf("run %d B = %d, replays = %d", i, b, sd.Replays)
+		}
+		if len(sd.fallbacks) != 1 {
+			t.Errorf("run %d fallbacks = %v", i, sd.fallbacks)
+		}
+	}
+	if cnt != 1 || peak != 1 {
+		t.Errorf("cnt = %d, peak = %d, expect %d, %d", cnt, peak, 1, 1)
+	}
+}
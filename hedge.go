@@ -0,0 +1,107 @@
+package sudu
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	hedge_samples     = 100
+	hedge_min_samples = 10
+)
+
+// 对冲执行，任务执行超过一定时间仍未结束时，再启动一次同样的执行，采用先成功的一次
+// Sudu中每次执行的写入都被暂存，只提交胜出的一次，另一次的写入被丢弃，其读取也不再被追踪
+// TaskGroup无法暂存任务的副作用，只保证任务只被认为完成一次
+// 每次执行分别占用并发限制以及资源的名额，名额不足时第二次执行需要等待
+// 同一个HedgePolicy可以被多个任务以及多次运行共享，耗时的记录也随之共享
+type HedgePolicy struct {
+	// 启动第二次执行前等待的时间
+	Delay time.Duration
+	// 取值(0, 1)，记录足够多的耗时后，以此分位数的耗时代替Delay
+	Percentile float64
+
+	lock    sync.Mutex
+	samples []time.Duration
+	next    int
+}
+
+func (hp *HedgePolicy) delay() time.Duration {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+
+	if hp.Percentile <= 0 || hp.Percentile >= 1 || len(hp.samples) < hedge_min_samples {
+		return hp.Delay
+	}
+	sorted := make([]time.Duration, len(hp.samples))
+	copy(sorted, hp.samples)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[int(float64(len(sorted))*hp.Percentile)]
+}
+
+// 只记录成功执行的耗时
+func (hp *HedgePolicy) observe(d time.Duration) {
+	hp.lock.Lock()
+	defer hp.lock.Unlock()
+
+	if len(hp.samples) < hedge_samples {
+		hp.samples = append(hp.samples, d)
+	} else {
+		hp.samples[hp.next] = d
+		hp.next = (hp.next + 1) % hedge_samples
+	}
+}
+
+type hedgeResult struct {
+	index   int
+	p       interface{}
+	elapsed time.Duration
+}
+
+// 执行attempt(0)，超时后再执行attempt(1)，返回先成功的一次
+// 都失败时返回先失败的一次；尚未对冲就已失败时直接返回，失败交由重试处理
+func (hp *HedgePolicy) race(spawn func(func()), attempt func(int) interface{}) (int, interface{}) {
+	results := make(chan hedgeResult, 2)
+	start := func(i int) {
+		spawn(func() {
+			begin := time.Now()
+			p := attempt(i)
+			results <- hedgeResult{i, p, time.Since(begin)}
+		})
+	}
+
+	start(0)
+	pending := 1
+
+	var timeout <-chan time.Time
+	if d := hp.delay(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var failed *hedgeResult
+	for {
+		select {
+		case <-timeout:
+			timeout = nil
+			start(1)
+			pending++
+		case r := <-results:
+			pending--
+			if r.p == nil {
+				hp.observe(r.elapsed)
+				return r.index, nil
+			}
+			if failed == nil {
+				failed = &r
+			}
+			if pending == 0 {
+				return failed.index, failed.p
+			}
+		}
+	}
+}
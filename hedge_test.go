@@ -0,0 +1,98 @@
+package sudu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuduHedge(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.SetHistory(10)
+
+	var attempts int32
+	hedge := &HedgePolicy{Delay: 5 * time.Millisecond}
+	sd.GoWith(TaskOptions{Hedge: hedge}, func(cg *ConditionGroup) {
+		a := cg.Require("A").(int)
+		n := atomic.AddInt32(&attempts, 1)
+		if n == 1 {
+			time.Sleep(50 * time.Millisecond)
+		}
+		cg.Satisfy("B", a*10+int(n))
+	})
+
+	sd.Satisfy("A", 1)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if b := sd.Require("B").(int); b != 12 {
+		t.Errorf("B = %d, expect the hedged attempt %d", b, 12)
+	}
+
+	// 较慢的一次结束后，其写入被丢弃
+	sd.Close()
+	if b := sd.Require("B").(int); b != 12 || len(sd.History("B")) != 1 {
+		t.Errorf("B = %d, history = %v, expect single write", b, sd.History("B"))
+	}
+}
+
+func TestTaskGroupHedge(t *testing.T) {
+	tg := NewTaskGroup()
+
+	var attempts int32
+	hedge := &HedgePolicy{Delay: 5 * time.Millisecond}
+	start := time.Now()
+	tg.GoWith(TaskOptions{Hedge: hedge}, func() {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	})
+
+	tg.Wait()
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond {
+		t.Errorf("elapsed = %v, expect hedged attempt to win", elapsed)
+	}
+	tg.Drain()
+	if n := atomic.LoadInt32(&attempts); n != 2 {
+		t.Errorf("attempts = %d, expect %d", n, 2)
+	}
+}
+
+func TestHedgeResources(t *testing.T) {
+	var running, peak, attempts int32
+	use := func() {
+		atomic.AddInt32(&attempts, 1)
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}
+
+	// 对冲的一次执行同样需要占用名额
+	opts := TaskOptions{
+		Hedge:     &HedgePolicy{Delay: 5 * time.Millisecond},
+		Resources: []string{"db"},
+	}
+	sd := NewSudu(nil)
+	sd.Resources.SetLimit("db", 1)
+	sd.GoWith(opts, func(cg *ConditionGroup) {
+		use()
+	})
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	// Close之后仍在排队的一次不再执行
+	sd.Close()
+
+	atomic.StoreInt32(&attempts, 0)
+	tg := NewTaskGroup()
+	tg.Resources.SetLimit("db", 1)
+	tg.GoWith(opts, use)
+	tg.Wait()
+	tg.Drain()
+
+	if peak != 1 || attempts != 2 {
+		t.Errorf("peak = %d, attempts = %d, expect %d, %d", peak, attempts, 1, 2)
+	}
+}
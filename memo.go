@@ -0,0 +1,114 @@
+package sudu
+
+import (
+	"reflect"
+)
+
+// 任务上一次确认成功的执行中读到以及写入的条件，随运行记录一起保存在cache中
+type taskMemo struct {
+	// 任务的标识，参见TaskOptions.MemoKey
+	Key interface{}
+	// 按第一次读到的顺序排列
+	Reads  []memoValue
+	Writes []memoValue
+}
+
+type memoValue struct {
+	Name   interface{}
+	Value  interface{}
+	Cancel *CancelMessage
+}
+
+// 未指定MemoKey时以任务本体的函数作为标识
+func memoKey(fx func(*ConditionGroup), key interface{}) interface{} {
+	if key != nil {
+		return key
+	}
+	return reflect.ValueOf(fx).Pointer()
+}
+
+// 找到上一次运行中同一个任务的记录，调用方需持有sd.lock
+// 指定了MemoKey时，任务的顺序即使发生了变化，也能找到对应的记录
+func (sd *Sudu) memo(id int, key interface{}, explicit bool) *taskMemo {
+	if p := sd.profiles[id]; p != nil && p.Memo != nil && sameValue(p.Memo.Key, key) {
+		return p.Memo
+	}
+	if explicit == false {
+		return nil
+	}
+	for _, p := range sd.profiles {
+		if p.Memo != nil && sameValue(p.Memo.Key, key) {
+			return p.Memo
+		}
+	}
+	return nil
+}
+
+// 只记录确认的（unlegacy）成功执行，读到过被取消的条件时不记录
+func newTaskMemo(t *task) *taskMemo {
+	if t.legacy_mode || t.fx_panic != nil || t.stale != nil {
+		return nil
+	}
+
+	memo := &taskMemo{
+		Key:    t.memo_key,
+		Reads:  make([]memoValue, 0, len(t.reads)),
+		Writes: make([]memoValue, 0, len(t.w_values)),
+	}
+	for _, name := range t.reads {
+		r_value := t.r_values[name]
+		if r_value.cmsg != nil {
+			return nil
+		}
+		memo.Reads = append(memo.Reads, memoValue{Name: name, Value: r_value.value})
+	}
+	for name, w_value := range t.w_values {
+		memo.Writes = append(memo.Writes, memoValue{Name: name, Value: w_value.value, Cancel: w_value.cmsg})
+	}
+	return memo
+}
+
+// 按记录中的顺序检查读到的条件，全部与记录相同时直接重放记录中的写入，不再执行任务本体
+// 检查时不阻塞，也不追踪读取：遇到第一个尚未就绪或者不同的条件就放弃，交由任务本体执行
+// 全部相同时才在同一次持有锁期间补上读取的追踪，之后的变更照常触发重做
+// 读到legacy的条件时，重放的写入同样是legacy的，与执行任务本体的预测效果一致
+func (t *task) replay() bool {
+	memo := t.memo
+	if memo == nil {
+		return false
+	}
+
+	t.cg.lock.Lock()
+	for _, r := range memo.Reads {
+		value, cmsg, ok := t.cg.inspect(r.Name)
+		if ok == false || cmsg != nil || sameValue(value, r.Value) == false {
+			t.cg.lock.Unlock()
+			return false
+		}
+	}
+	for _, r := range memo.Reads {
+		t.cg.emitReadEvent(r.Name)
+	}
+	t.cg.lock.Unlock()
+
+	for _, w := range memo.Writes {
+		if w.Cancel != nil {
+			t.cg.Cancel(w.Name, w.Cancel)
+		} else {
+			t.cg.Satisfy(w.Name, w.Value)
+		}
+	}
+	t.replayed = true
+	return true
+}
+
+// 不可比较的值（例如slice、map）退化为reflect.DeepEqual
+func sameValue(a, b interface{}) (same bool) {
+	defer func() {
+		if recover() != nil {
+			same = reflect.DeepEqual(a, b)
+		}
+	}()
+
+	return a == b
+}
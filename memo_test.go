@@ -0,0 +1,126 @@
+package sudu
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuduMemo(t *testing.T) {
+	cache := NewCache("memo", fmt.Sprintf("%d", time.Now().UnixNano()))
+
+	var cnt int
+	run := func(a int) *Sudu {
+		sd := NewSudu(cache)
+		sd.GoWith(TaskOptions{Memo: true}, func(cg *ConditionGroup) {
+			cnt++
+			v := cg.Require("A").([]int)
+			cg.Satisfy("B", v[0]*2)
+		})
+		sd.Go(func(cg *ConditionGroup) {
+			cg.Satisfy("C", cg.Require("B").(int)+1)
+		})
+		sd.Satisfy("A", []int{a})
+		if err := sd.Wait(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return sd
+	}
+
+	run(1)
+	sd := run(1)
+	if cnt != 1 || sd.Replays != 1 {
+		t.Errorf("cnt = %d, replays = %d, expect replay without executing", cnt, sd.Replays)
+	}
+	if c := sd.Require("C").(int); c != 3 {
+		t.Errorf("C = %d, expect %d", c, 3)
+	}
+
+	sd = run(2)
+	if cnt != 2 || sd.Replays != 0 || sd.Require("C").(int) != 5 {
+		t.Errorf("cnt = %d, replays = %d, C = %v, expect execution on new input", cnt, sd.Replays, sd.Require("C"))
+	}
+}
+
+func TestSuduMemoKey(t *testing.T) {
+	cache := NewCache("memo", fmt.Sprintf("key-%d", time.Now().UnixNano()))
+
+	var cnt int32
+	double := func(cg *ConditionGroup) {
+		atomic.AddInt32(&cnt, 1)
+		cg.Satisfy("B", cg.Require("A").(int)*2)
+	}
+	triple := func(cg *ConditionGroup) {
+		atomic.AddInt32(&cnt, 1)
+		cg.Satisfy("C", cg.Require("A").(int)*3)
+	}
+	run := func(swap bool, opts1, opts2 TaskOptions) *Sudu {
+		sd := NewSudu(cache)
+		if swap {
+			sd.GoWith(opts2, triple)
+			sd.GoWith(opts1, double)
+		} else {
+			sd.GoWith(opts1, double)
+			sd.GoWith(opts2, triple)
+		}
+		sd.Satisfy("A", 1)
+		if err := sd.Wait(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return sd
+	}
+
+	// 任务调整了顺序，没有MemoKey时不会错用其他任务的记录
+	memo := TaskOptions{Memo: true}
+	run(false, memo, memo)
+	sd := run(true, memo, memo)
+	if sd.Replays != 0 || sd.Require("B").(int) != 2 || sd.Require("C").(int) != 3 {
+		t.Errorf("replays = %d, B = %v, C = %v", sd.Replays, sd.Require("B"), sd.Require("C"))
+	}
+
+	// 指定MemoKey时，调整顺序后仍能找到各自的记录
+	atomic.StoreInt32(&cnt, 0)
+	opts1 := TaskOptions{Memo: true, MemoKey: "double"}
+	opts2 := TaskOptions{Memo: true, MemoKey: "triple"}
+	run(false, opts1, opts2)
+	sd = run(true, opts1, opts2)
+	if cnt != 2 || sd.Replays != 2 || sd.Require("B").(int) != 2 || sd.Require("C").(int) != 3 {
+		t.Errorf("cnt = %d, replays = %d, B = %v, C = %v", cnt, sd.Replays, sd.Require("B"), sd.Require("C"))
+	}
+}
+
+func TestSuduMemoMismatch(t *testing.T) {
+	cache := NewCache("memo", fmt.Sprintf("mismatch-%d", time.Now().UnixNano()))
+
+	var cnt int32
+	run := func(b int) *Sudu {
+		sd := NewSudu(cache)
+		sd.GoWith(TaskOptions{Memo: true}, func(cg *ConditionGroup) {
+			atomic.AddInt32(&cnt, 1)
+			v := cg.Require("B").(int)
+			if v == 0 {
+				v = cg.Require("A").(int)
+			}
+			cg.Satisfy("C", v)
+		})
+		sd.Satisfy("A", 1, "B", b)
+		if err := sd.Wait(); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+		return sd
+	}
+
+	run(0)
+	sd := run(2)
+	if cnt != 2 || sd.Replays != 0 || sd.Require("C").(int) != 2 {
+		t.Errorf("cnt = %d, replays = %d, C = %v", cnt, sd.Replays, sd.Require("C"))
+	}
+
+	// 放弃的重放没有留下对A的追踪，A的变更不会引起重做
+	sd.Satisfy("A", 3)
+	sd.Close()
+	if cnt != 2 {
+		t.Errorf("cnt = %d, expect no redo on A", cnt)
+	}
+}
@@ -0,0 +1,120 @@
+package sudu
+
+import (
+	"time"
+
+	gcache "github.com/patrickmn/go-cache"
+)
+
+// 任务在上一次成功运行中的记录，按任务id（即Go的顺序）索引
+// 用于计算关键路径，与条件分开保存，不影响bucket中的条目以及统计
+type taskProfile struct {
+	Duration time.Duration
+	Reads    []interface{}
+	Writes   []interface{}
+	// 只有声明了Memo的任务才会记录
+	Memo *taskMemo
+}
+
+var profileCaches map[*gcache.Cache]*gcache.Cache = map[*gcache.Cache]*gcache.Cache{}
+
+func profileBucket(gc *gcache.Cache) *gcache.Cache {
+	cacheLock.Lock()
+	defer cacheLock.Unlock()
+
+	pc := profileCaches[gc]
+	if pc == nil {
+		pc = gcache.New(24*time.Hour, 1*time.Hour)
+		profileCaches[gc] = pc
+	}
+	return pc
+}
+
+func (c *Cache) saveProfile(profiles map[int]*taskProfile) {
+	profileBucket(c.Cache).SetDefault(c.Key, profiles)
+}
+
+func (c *Cache) loadProfile() map[int]*taskProfile {
+	if v, ok := profileBucket(c.Cache).Get(c.Key); ok {
+		return v.(map[int]*taskProfile)
+	}
+	return nil
+}
+
+func (sd *Sudu) profile() map[int]*taskProfile {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	profiles := make(map[int]*taskProfile, len(sd.tasks))
+	for id, task := range sd.tasks {
+		p := &taskProfile{
+			Duration: task.elapsed,
+			Reads:    make([]interface{}, 0, len(task.r_values)),
+			Writes:   make([]interface{}, 0, len(task.w_values)),
+		}
+		for name := range task.r_values {
+			p.Reads = append(p.Reads, name)
+		}
+		for name := range task.w_values {
+			p.Writes = append(p.Writes, name)
+		}
+		if task.memoize {
+			p.Memo = newTaskMemo(task)
+		}
+		profiles[id] = p
+	}
+	return profiles
+}
+
+// 任务所在关键路径的长度，即自身的耗时加上所有下游任务中最长的路径
+// 只依据上一次运行的记录，新增的任务没有记录，长度为0；调用方需持有sd.lock
+func (sd *Sudu) weight(id int) time.Duration {
+	if sd.weights == nil {
+		sd.weights = criticalPath(sd.profiles)
+	}
+	return sd.weights[id]
+}
+
+func criticalPath(profiles map[int]*taskProfile) map[int]time.Duration {
+	readers := make(map[interface{}][]int)
+	for id, p := range profiles {
+		for _, name := range p.Reads {
+			readers[name] = append(readers[name], id)
+		}
+	}
+
+	weights := make(map[int]time.Duration, len(profiles))
+	visiting := make(map[int]bool)
+	var walk func(id int) time.Duration
+	walk = func(id int) time.Duration {
+		if w, ok := weights[id]; ok {
+			return w
+		}
+		if visiting[id] {
+			// 依赖成环，不再继续深入
+			return 0
+		}
+		visiting[id] = true
+
+		var longest time.Duration
+		for _, name := range profiles[id].Writes {
+			for _, reader := range readers[name] {
+				if reader == id {
+					continue
+				}
+				if w := walk(reader); w > longest {
+					longest = w
+				}
+			}
+		}
+
+		delete(visiting, id)
+		weights[id] = profiles[id].Duration + longest
+		return weights[id]
+	}
+
+	for id := range profiles {
+		walk(id)
+	}
+	return weights
+}
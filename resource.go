@@ -0,0 +1,99 @@
+package sudu
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 命名资源的信号量，每种资源（例如数据库、搜索服务）各自限制同时使用的任务数量
+// 同一个Resources可以被多个Sudu以及TaskGroup共享
+type Resources struct {
+	lock  sync.Mutex
+	gates map[string]*scheduler
+	stats map[string]*ResourceStats
+}
+
+// 资源的使用统计
+type ResourceStats struct {
+	Limit int
+	// 得到名额的次数，阻塞在Want中让出名额后重新得到也计算在内
+	Acquired int64
+	// 等待名额的总时间
+	Waited time.Duration
+}
+
+func NewResources() *Resources {
+	return &Resources{
+		lock:  sync.Mutex{},
+		gates: make(map[string]*scheduler),
+		stats: make(map[string]*ResourceStats),
+	}
+}
+
+// 资源同时使用的数量上限，n <= 0 时不做限制，未设置的资源同样不做限制
+func (rs *Resources) SetLimit(name string, n int) {
+	rs.gate(name).setLimit(n)
+
+	rs.lock.Lock()
+	rs.stats[name].Limit = n
+	rs.lock.Unlock()
+}
+
+func (rs *Resources) Stats(name string) ResourceStats {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	if st := rs.stats[name]; st != nil {
+		return *st
+	}
+	return ResourceStats{}
+}
+
+func (rs *Resources) gate(name string) *scheduler {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+
+	s := rs.gates[name]
+	if s == nil {
+		s = newScheduler()
+		rs.gates[name] = s
+		rs.stats[name] = &ResourceStats{}
+	}
+	return s
+}
+
+// 按名字的顺序依次得到全部资源的名额，避免相互等待
+func (rs *Resources) acquire(names []string, priority int, weight time.Duration) {
+	for _, name := range names {
+		waited := rs.gate(name).acquire(priority, weight)
+
+		rs.lock.Lock()
+		st := rs.stats[name]
+		st.Acquired++
+		st.Waited += waited
+		rs.lock.Unlock()
+	}
+}
+
+func (rs *Resources) release(names []string) {
+	for i := len(names) - 1; i >= 0; i-- {
+		rs.gate(names[i]).release()
+	}
+}
+
+func sortedResources(names []string) []string {
+	if len(names) == 0 {
+		return nil
+	}
+	sorted := make([]string, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] == false {
+			seen[name] = true
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,65 @@
+package sudu
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuduResources(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.Resources.SetLimit("db", 1)
+
+	var running, peak int32
+	use := func(cg *ConditionGroup) {
+		if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(2 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	}
+
+	db := TaskOptions{Resources: []string{"db"}}
+	// 阻塞在Want中时让出db，其余任务不受影响
+	sd.GoWith(db, func(cg *ConditionGroup) {
+		a := cg.Require("A").(int)
+		use(cg)
+		cg.Satisfy("B", a)
+	})
+	sd.GoWith(db, use, use, use)
+
+	time.Sleep(20 * time.Millisecond)
+	sd.Satisfy("A", 1)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if peak != 1 {
+		t.Errorf("peak = %d, expect %d", peak, 1)
+	}
+	st := sd.Resources.Stats("db")
+	if st.Limit != 1 || st.Acquired != 5 || st.Waited <= 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestTaskGroupResources(t *testing.T) {
+	tg := NewTaskGroup()
+	tg.Resources.SetLimit("api", 2)
+
+	var running, peak int32
+	for i := 0; i < 6; i++ {
+		tg.GoWith(TaskOptions{Resources: []string{"api"}}, func() {
+			if n := atomic.AddInt32(&running, 1); n > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, n)
+			}
+			time.Sleep(2 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		})
+	}
+	tg.Wait()
+
+	if peak != 2 {
+		t.Errorf("peak = %d, expect %d", peak, 2)
+	}
+}
@@ -0,0 +1,71 @@
+package sudu
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// 任务失败后的重试策略
+// 只有确认的（unlegacy）失败才会重试
+// legacy的失败在条件确认后，值发生变化时重做，值未变时作为第一次执行的失败进行重试
+// 只重试panic(error)，其他类型的panic被认为是程序错误
+type RetryPolicy struct {
+	// 最多执行的次数，包括首次执行，<= 1 时不重试
+	Attempts int
+	// 首次重试前的等待时间，之后每次乘以Multiplier（默认为2），不超过MaxBackoff（0表示不限制）
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Multiplier float64
+	// 等待时间的随机浮动比例，取值[0, 1]
+	Jitter float64
+	// 判断error是否值得重试，默认除了条件被取消（*CancelMessage）之外都重试
+	Retryable func(err error) bool
+	// 每次重试之前调用，attempt为即将开始的是第几次执行
+	OnRetry func(attempt int, err error)
+}
+
+// 第attempt次执行以p失败后，是否需要重试，以及重试前需要等待的时间
+func (rp *RetryPolicy) next(attempt int, p interface{}) (time.Duration, bool) {
+	if rp == nil || attempt >= rp.Attempts {
+		return 0, false
+	}
+	err, ok := p.(error)
+	if ok == false {
+		return 0, false
+	}
+	if rp.Retryable != nil {
+		if rp.Retryable(err) == false {
+			return 0, false
+		}
+	} else {
+		var cmsg *CancelMessage
+		if errors.As(err, &cmsg) {
+			return 0, false
+		}
+	}
+
+	multiplier := rp.Multiplier
+	if multiplier <= 0 {
+		multiplier = 2
+	}
+	backoff := float64(rp.Backoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= multiplier
+		if rp.MaxBackoff > 0 && backoff > float64(rp.MaxBackoff) {
+			backoff = float64(rp.MaxBackoff)
+			break
+		}
+	}
+	if rp.Jitter > 0 {
+		backoff += backoff * rp.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff), true
+}
+
+// 确定重试时调用，此时p一定是error
+func (rp *RetryPolicy) retried(attempt int, p interface{}) {
+	if rp.OnRetry != nil {
+		rp.OnRetry(attempt+1, p.(error))
+	}
+}
@@ -0,0 +1,96 @@
+package sudu
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSuduRetry(t *testing.T) {
+	sd := NewSudu(nil)
+
+	var attempts []int
+	retry := &RetryPolicy{
+		Attempts: 3,
+		Backoff:  time.Millisecond,
+		OnRetry: func(attempt int, err error) {
+			attempts = append(attempts, attempt)
+		},
+	}
+
+	var cnt int
+	sd.GoWith(TaskOptions{Retry: retry}, func(cg *ConditionGroup) {
+		a := cg.Require("A").(int)
+		cnt++
+		if cnt < 3 {
+			panic(fmt.Errorf("transient %d", cnt))
+		}
+		cg.Satisfy("B", a+1)
+	})
+
+	sd.Satisfy("A", 1)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if sd.Require("B").(int) != 2 || sd.Retries != 2 {
+		t.Errorf("B = %v, retries = %d, expect 2, 2", sd.Require("B"), sd.Retries)
+	}
+	if len(attempts) != 2 || attempts[0] != 2 || attempts[1] != 3 {
+		t.Errorf("attempts = %v, expect [2 3]", attempts)
+	}
+}
+
+func TestTaskGroupRetry(t *testing.T) {
+	tg := NewTaskGroup()
+
+	fatal := errors.New("fatal")
+	retry := &RetryPolicy{
+		Attempts: 5,
+		Retryable: func(err error) bool {
+			return err != fatal
+		},
+	}
+
+	var cnt int
+	tg.GoWith(TaskOptions{Retry: retry}, func() {
+		cnt++
+		if cnt < 2 {
+			panic(errors.New("transient"))
+		}
+		panic(fatal)
+	})
+
+	if err := tg.Wait(); err != fatal {
+		t.Errorf("Wait = %v, expect %v", err, fatal)
+	}
+	if cnt != 2 || tg.Retries != 1 {
+		t.Errorf("cnt = %d, retries = %d, expect 2, 1", cnt, tg.Retries)
+	}
+}
+
+func TestSuduRetryLegacy(t *testing.T) {
+	sd := NewSudu(nil)
+
+	var cnt int32
+	retry := &RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	sd.GoWith(TaskOptions{Retry: retry}, func(cg *ConditionGroup) {
+		a := cg.Require("A").(int)
+		if atomic.AddInt32(&cnt, 1) == 1 {
+			panic(errors.New("transient"))
+		}
+		cg.Satisfy("B", a+1)
+	})
+
+	// legacy的一轮失败，条件确认后值未变，失败被确认，随之重试
+	sd.SatisfyLegacy("A", 1)
+	time.Sleep(10 * time.Millisecond)
+	sd.Satisfy("A", 1)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if sd.Require("B").(int) != 2 || cnt != 2 || sd.Retries != 1 {
+		t.Errorf("B = %v, cnt = %d, retries = %d, expect 2, 2, 1", sd.Require("B"), cnt, sd.Retries)
+	}
+}
@@ -0,0 +1,123 @@
+package sudu
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// 按优先级分配执行名额的信号量，limit <= 0 时不做限制
+// 名额不足时，优先级高的先得到名额，其次是关键路径更长（weight更大）的，最后按先来后到
+type scheduler struct {
+	lock    sync.Mutex
+	limit   int
+	running int
+	seq     uint64
+	waiting tickets
+}
+
+type ticket struct {
+	priority int
+	weight   time.Duration
+	seq      uint64
+	ready    chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{
+		lock: sync.Mutex{},
+	}
+}
+
+func (s *scheduler) setLimit(n int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.limit = n
+	s.dispatch()
+}
+
+// 阻塞直到得到名额，返回等待的时间
+func (s *scheduler) acquire(priority int, weight time.Duration) time.Duration {
+	s.lock.Lock()
+	if s.limit <= 0 || (s.running < s.limit && len(s.waiting) == 0) {
+		s.running++
+		s.lock.Unlock()
+		return 0
+	}
+
+	s.seq++
+	t := &ticket{
+		priority: priority,
+		weight:   weight,
+		seq:      s.seq,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(&s.waiting, t)
+	s.lock.Unlock()
+
+	start := time.Now()
+	<-t.ready
+	return time.Since(start)
+}
+
+// 不阻塞，没有名额时返回false
+func (s *scheduler) tryAcquire() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.limit <= 0 || (s.running < s.limit && len(s.waiting) == 0) {
+		s.running++
+		return true
+	}
+	return false
+}
+
+func (s *scheduler) release() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.running--
+	s.dispatch()
+}
+
+// 调用方需持有锁
+func (s *scheduler) dispatch() {
+	for len(s.waiting) > 0 && (s.limit <= 0 || s.running < s.limit) {
+		t := heap.Pop(&s.waiting).(*ticket)
+		s.running++
+		close(t.ready)
+	}
+}
+
+// container/heap
+type tickets []*ticket
+
+func (ts tickets) Len() int {
+	return len(ts)
+}
+
+func (ts tickets) Less(i, j int) bool {
+	if ts[i].priority != ts[j].priority {
+		return ts[i].priority > ts[j].priority
+	}
+	if ts[i].weight != ts[j].weight {
+		return ts[i].weight > ts[j].weight
+	}
+	return ts[i].seq < ts[j].seq
+}
+
+func (ts tickets) Swap(i, j int) {
+	ts[i], ts[j] = ts[j], ts[i]
+}
+
+func (ts *tickets) Push(x interface{}) {
+	*ts = append(*ts, x.(*ticket))
+}
+
+func (ts *tickets) Pop() interface{} {
+	old := *ts
+	t := old[len(old)-1]
+	*ts = old[:len(old)-1]
+	return t
+}
@@ -0,0 +1,69 @@
+package sudu
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSuduPriority(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.SetLimit(1)
+
+	var lock sync.Mutex
+	var order []int
+	run := func(n int) func(*ConditionGroup) {
+		return func(cg *ConditionGroup) {
+			lock.Lock()
+			order = append(order, n)
+			lock.Unlock()
+		}
+	}
+	queued := func(n int) {
+		for {
+			sd.gate.lock.Lock()
+			running, waiting := sd.gate.running, len(sd.gate.waiting)
+			sd.gate.lock.Unlock()
+			if running == 1 && waiting == n {
+				return
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+
+	// 先占住唯一的名额，其余任务全部排队后再放行
+	release := make(chan struct{})
+	sd.GoWith(TaskOptions{Priority: 10}, func(cg *ConditionGroup) {
+		run(0)(cg)
+		<-release
+	})
+	queued(0)
+	sd.GoWith(TaskOptions{Priority: 1}, run(1))
+	sd.GoWith(TaskOptions{Priority: 3}, run(3))
+	sd.GoWith(TaskOptions{Priority: 2}, run(2))
+	queued(3)
+	close(release)
+
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	expect := []int{0, 3, 2, 1}
+	for i, n := range expect {
+		if order[i] != n {
+			t.Fatalf("order = %v, expect %v", order, expect)
+		}
+	}
+}
+
+func TestCriticalPath(t *testing.T) {
+	profiles := map[int]*taskProfile{
+		0: {Duration: 10 * time.Millisecond, Writes: []interface{}{"A"}},
+		1: {Duration: 5 * time.Millisecond, Reads: []interface{}{"A"}, Writes: []interface{}{"B"}},
+		2: {Duration: 20 * time.Millisecond, Reads: []interface{}{"B"}},
+		3: {Duration: 30 * time.Millisecond},
+	}
+	weights := criticalPath(profiles)
+	if weights[0] != 35*time.Millisecond || weights[1] != 25*time.Millisecond || weights[3] != 30*time.Millisecond {
+		t.Errorf("weights = %v", weights)
+	}
+}
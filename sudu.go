@@ -1,9 +1,9 @@
 package sudu
 
 import (
-	//"fmt"
+	"fmt"
 	"sync"
-	//"time"
+	"time"
 )
 
 // Sudu，用于将存在条件依赖的并发调用，利用cache机制将依赖解开，变成并发调用
@@ -27,11 +27,66 @@ type Sudu struct {
 	Tasks   int
 	Running int
 	WaitAll bool
+	// 任务失败后重试的总次数，参见RetryPolicy
+	Retries int
+	// 重放上一次运行的记录而没有执行任务本体的次数，参见TaskOptions.Memo
+	Replays int
+
+	// 所有任务都至少完成一轮（允许是legacy的）之后，Wait即返回
+	// 条件的确认以及cache的更新在后台继续进行，最终结果通过Settled通知
+	WaitLegacy bool
+	Settled    func(error)
+
+	// 尚未完成首轮的任务数量，任务状态的每次变更都会广播settle
+	fresh        int
+	settle       *sync.Cond
+	revalidating bool
+
+	// 执行名额，默认不做限制，参见SetLimit
+	gate *scheduler
+	// 任务声明的命名资源，默认每个Sudu独立，可以替换为共享的Resources
+	Resources *Resources
+	// 名额不足时，优先执行上一次运行中处于关键路径上的任务，需要cache保存运行记录
+	CriticalPath bool
+	profiles     map[int]*taskProfile
+	weights      map[int]time.Duration
+
+	// 常驻模式，每当所有任务静止时，将此期间的结果放入队列，由React的回调依次处理
+	reacting *queue
+	reacted  chan struct{}
+	unreact  func()
+	// Close或Abort之后不再开始任何任务
+	closed bool
+	// 所有任务以及后台确认启动的goroutine，Close等待它们全部退出
+	alive sync.WaitGroup
+	// 通过Nest嵌套的子Sudu，随之一起Close或Abort
+	children []*Sudu
+	// 作为Nest的子Sudu时，确认的失败需要通知父级中对应的任务重做
+	nested func()
+
+	// 允许降级的条件，以及已经降级确认的条件，参见Fallback
+	fallbacks   map[interface{}]bool
+	degraded    []interface{}
+	degrade_err error
 
 	cache *Cache
+
+	// 共享模式下相同Key的先行者，非nil时本Sudu不执行任何任务
+	// 只订阅先行者已确认的条件，并等待先行者的结果
+	leader *Sudu
+	// 作为先行者时，任务全部结束并且结果都已确认（或失败）时关闭，用于通知所有跟随者
+	// 不依赖先行者的调用方是否调用Wait，避免跟随者因先行者被放弃而永远等待
+	landed chan struct{}
+	// 最近一轮的结果仍是legacy的任务数量，不为0时说明真正的输入尚未全部到来
+	unconfirmed int
 }
 
 func NewSudu(c *Cache) *Sudu {
+	return newSudu(c, nil)
+}
+
+// prepare在还原cache之前执行，用于注册校验、派生条件等需要先于任何写入的声明
+func newSudu(c *Cache, prepare func(*Sudu)) *Sudu {
 	cg := NewConditionGroup()
 
 	sd := &Sudu{
@@ -43,39 +98,178 @@ func NewSudu(c *Cache) *Sudu {
 		tasks:   make(map[int]*task),
 		fx_lock: sync.Mutex{},
 
+		gate:      newScheduler(),
+		Resources: NewResources(),
+
 		cache: c,
 	}
+	sd.settle = sync.NewCond(&sd.lock)
+	if prepare != nil {
+		prepare(sd)
+	}
+	if c != nil && c.Shared {
+		if leader := c.join(sd); leader != nil {
+			sd.follow(leader)
+			return sd
+		}
+		sd.landed = make(chan struct{})
+	}
+	if c != nil {
+		sd.profiles = c.loadProfile()
+	}
 	sd.cacheRestore()
 	return sd
 }
 
+// 作为跟随者，同步先行者当前以及后续全部已确认的条件
+func (sd *Sudu) follow(leader *Sudu) {
+	sd.leader = leader
+
+	cg := &leader.ConditionGroup
+	cg.lock.Lock()
+	defer cg.lock.Unlock()
+
+	sd.relay(cg, cg.conditions()...)
+	cg.listenWriteEvent(func(names ...interface{}) {
+		sd.relay(cg, names...)
+	})
+}
+
+// 只转发unlegacy的条件以及retract，legacy的预测值对跟随者没有意义
+func (sd *Sudu) relay(cg *ConditionGroup, names ...interface{}) {
+	nvs1 := make([]interface{}, 0)
+	nvs2 := make([]interface{}, 0)
+	retracted := make([]interface{}, 0)
+	for _, name := range names {
+		if cg.legacy[name] {
+			continue
+		}
+		value, cmsg, ok := cg.inspect(name)
+		if ok == false {
+			retracted = append(retracted, name)
+			continue
+		}
+		if cmsg == nil {
+			nvs1 = append(nvs1, name, value)
+		} else {
+			nvs2 = append(nvs2, name, cmsg)
+		}
+	}
+	if len(nvs1) > 0 {
+		sd.satisfyValid(false, nvs1...)
+	}
+	if len(nvs2) > 0 {
+		sd.Cancel(nvs2...)
+	}
+	if len(retracted) > 0 {
+		sd.Retract(retracted...)
+	}
+}
+
 // 预留，用于注册复杂类型的值比较方法
 // 简单的内置比较直接采用==方式，无法适用于复杂结构
 //func (sd *Sudu) CompareRule(name interface{}, fx func(v1, v2 interface{}) bool) {
 //}
 
-func (sd *Sudu) task(fx func(*ConditionGroup)) int {
+func (sd *Sudu) task(fx func(*ConditionGroup), opts TaskOptions) int {
 	id := sd.Tasks
 
 	task := newTask(id, &sd.ConditionGroup, fx)
+	task.gate = sd.gate
+	task.alive = &sd.alive
+	task.priority = opts.Priority
+	task.retry = opts.Retry
+	task.hedge = opts.Hedge
+	task.pool = sd.Resources
+	task.resources = sortedResources(opts.Resources)
+	if opts.Memo {
+		task.memoize = true
+		task.memo_key = memoKey(fx, opts.MemoKey)
+		task.memo = sd.memo(id, task.memo_key, opts.MemoKey != nil)
+	}
+	task.fallback = sd.fallback
+	task.halted = sd.halted
+	if sd.CriticalPath {
+		task.weight = sd.weight(id)
+	}
 	sd.tasks[id] = task
 
 	sd.Tasks++
-	sd.wg.Add(1)
+	// 失败后Wait已经被释放，此后添加的任务不再计入
+	if sd.fx_panic == nil || sd.WaitAll {
+		sd.wg.Add(1)
+	}
 	sd.Running++
+	sd.fresh++
 
 	doing := true
 	round := 0
+	first := true
+	legacy := false
 
 	task.do(func(state int, redo bool) (do bool) {
+		// 在释放sd.lock之后通知父级，父级的任务重做需要父级的锁
+		var nested func()
+		defer func() {
+			if nested != nil {
+				nested()
+			}
+		}()
+
 		sd.lock.Lock()
 		defer sd.lock.Unlock()
+		defer sd.settle.Broadcast()
 		//fmt.Printf("%s, id = %d, round = %d, state = %d\n", time.Now(), id, round, state)
+		if state == task_state_retry {
+			// 重试仍属于同一轮，不影响任何计数
+			if sd.closed || (sd.fx_panic != nil && sd.WaitAll == false) {
+				return false
+			}
+			sd.Retries++
+			return true
+		}
+		if state == task_state_success_legacy || state == task_state_success {
+			if task.replayed && doing {
+				sd.Replays++
+			}
+		}
+		if state == task_state_degraded {
+			sd.degrade(task)
+			state = task_state_success
+		}
+		if state != task_state_start && first {
+			first = false
+			sd.fresh--
+		}
+		if state == task_state_success_legacy || state == task_state_fail_legacy {
+			if legacy == false {
+				legacy = true
+				sd.unconfirmed++
+			}
+		} else if state != task_state_start && legacy {
+			legacy = false
+			sd.unconfirmed--
+		}
+
+		if sd.closed {
+			if doing {
+				doing = false
+				sd.Running--
+				if sd.fx_panic == nil || sd.WaitAll {
+					sd.wg.Done()
+				}
+				round++
+			}
+			return false
+		}
+
 		if state == task_state_fail && sd.fx_panic == nil {
 			// found the first panic from unlegacy task, stop!
 			sd.fx_panic = task.fx_panic
+			nested = sd.nested
 			if sd.WaitAll == false {
 				sd.wg.Add(sd.Running * -1)
+				sd.land()
 			}
 		}
 
@@ -91,9 +285,16 @@ func (sd *Sudu) task(fx func(*ConditionGroup)) int {
 					doing = false
 					sd.Running--
 					sd.wg.Done()
+					if sd.Running == 0 {
+						sd.quiesce()
+					}
 				}
 				round++
 			}
+			// 输出仍有legacy的，先行者不能就此结束
+			if state != task_state_start && sd.Running == 0 && sd.unconfirmed == 0 {
+				sd.land()
+			}
 			return true
 		}
 
@@ -111,6 +312,7 @@ func (sd *Sudu) task(fx func(*ConditionGroup)) int {
 func (sd *Sudu) cacheSave() {
 	if sd.cache != nil {
 		sd.cache.Set(sd.Conditions())
+		sd.cache.saveProfile(sd.profile())
 	}
 }
 
@@ -118,34 +320,165 @@ func (sd *Sudu) cacheSave() {
 // 从cache中还原的条件，均会被置为legacy状态
 func (sd *Sudu) cacheRestore() {
 	if sd.cache != nil {
-		sd.SatisfyLegacy(sd.cache.Get()...)
+		sd.SatisfyLegacy(sd.cache.restore()...)
 	}
 }
 
+// 跟随者不执行任务，结果全部来自先行者
+// 任务中也可以调用Go添加新的任务，只要此时Wait尚未返回，Wait就会一并等待它们
+// 任务每次重做都会再次执行其中的Go，需要由任务自身避免重复添加
 func (sd *Sudu) Go(fxs ...func(*ConditionGroup)) {
+	sd.GoWith(TaskOptions{}, fxs...)
+}
+
+func (sd *Sudu) GoWith(opts TaskOptions, fxs ...func(*ConditionGroup)) {
+	if sd.leader != nil {
+		return
+	}
+
 	sd.lock.Lock()
 	defer sd.lock.Unlock()
 
 	for _, fx := range fxs {
-		sd.task(fx)
+		sd.task(fx, opts)
 	}
 }
 
+// 同时执行的任务数量上限，n <= 0 时不做限制
+// 阻塞在Want中的任务不占用名额
+func (sd *Sudu) SetLimit(n int) {
+	sd.gate.setLimit(n)
+}
+
 func (sd *Sudu) Wait() error {
+	if sd.leader != nil {
+		<-sd.leader.landed
+
+		sd.leader.lock.Lock()
+		p := sd.leader.fx_panic
+		sd.leader.lock.Unlock()
+		return sd.leader.result(p)
+	}
+
+	if sd.WaitLegacy {
+		return sd.waitLegacy()
+	}
+	return sd.wait()
+}
+
+func (sd *Sudu) wait() error {
 	sd.wg.Wait()
 
+	p := sd.conclude()
+	return sd.result(p)
+}
+
+// 一次运行结束，保存cache并通知跟随者，返回运行的结果
+// cacheSave需要条件的锁，不能在持有sd.lock的情况下进行
+func (sd *Sudu) conclude() interface{} {
 	sd.lock.Lock()
-	defer sd.lock.Unlock()
+	p := sd.fx_panic
+	degraded := len(sd.degraded) > 0
+	sd.lock.Unlock()
 
-	if sd.fx_panic == nil {
+	// 降级的结果不能作为新的值保存
+	if p == nil && degraded == false {
 		sd.cacheSave()
+	}
+
+	sd.lock.Lock()
+	sd.land()
+	sd.lock.Unlock()
+	return p
+}
+
+// 等待所有任务完成首轮，出错时与常规的Wait保持一致
+// 否则在后台继续等待确认，由Settled报告最终的结果
+func (sd *Sudu) waitLegacy() error {
+	sd.lock.Lock()
+	for sd.fresh > 0 && (sd.fx_panic == nil || sd.WaitAll) {
+		sd.settle.Wait()
+	}
+	if sd.fx_panic != nil {
+		sd.lock.Unlock()
+		return sd.wait()
+	}
+	if sd.revalidating == false {
+		sd.revalidating = true
+		sd.alive.Add(1)
+		go func() {
+			defer sd.alive.Done()
+			sd.revalidate()
+		}()
+	}
+	sd.lock.Unlock()
+	return nil
+}
+
+func (sd *Sudu) revalidate() {
+	sd.wg.Wait()
+
+	p := sd.conclude()
+	sd.lock.Lock()
+	sd.revalidating = false
+	sd.lock.Unlock()
+
+	if sd.Settled != nil {
+		if p != nil {
+			sd.Settled(settledError(p))
+		} else {
+			sd.Settled(sd.result(nil))
+		}
+	}
+}
+
+// 后台报告结果时不能panic，非error的panic值转换为error
+func settledError(p interface{}) error {
+	err, ok := p.(error)
+	if p != nil && ok == false {
+		err = fmt.Errorf("%v", p)
+	}
+	return err
+}
+
+// 仍处于legacy状态，或者以过期的值降级确认的条件
+// WaitLegacy模式下，Wait返回时这些条件的值尚未得到确认
+func (sd *Sudu) Unconfirmed() []interface{} {
+	sd.ConditionGroup.lock.Lock()
+	defer sd.ConditionGroup.lock.Unlock()
+
+	names := make([]interface{}, 0)
+	for name, legacy := range sd.legacy {
+		if legacy || sd.stale[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// 先行者结束，注销共享登记并通知跟随者，调用方需持有sd.lock
+func (sd *Sudu) land() {
+	if sd.landed == nil {
+		return
+	}
+	select {
+	case <-sd.landed:
+	default:
+		sd.cache.leave(sd)
+		close(sd.landed)
+	}
+}
+
+// panic的值如果是error，则作为返回值，否则触发同样的panic
+func panicError(p interface{}) error {
+	if p == nil {
 		return nil
 	}
 
-	if err, ok := sd.fx_panic.(error); ok {
+	if err, ok := p.(error); ok {
 		return err
 	} else {
-		panic(sd.fx_panic)
+		panic(p)
 	}
 }
 
@@ -155,7 +488,12 @@ func (sd *Sudu) SatisfyLegacy(nvs ...interface{}) {
 }
 
 func (sd *Sudu) Conditions() []interface{} {
+	if sd.leader != nil {
+		return sd.leader.Conditions()
+	}
+
 	cs := make([]interface{}, 0)
+	sd.lock.Lock()
 	for _, task := range sd.tasks {
 		for name, cvalue := range task.w_values {
 			if cvalue.cmsg == nil && cvalue.legacy == false {
@@ -163,5 +501,15 @@ func (sd *Sudu) Conditions() []interface{} {
 			}
 		}
 	}
+	sd.lock.Unlock()
+
+	// 派生条件与任务的输出一样参与cache
+	sd.ConditionGroup.lock.Lock()
+	defer sd.ConditionGroup.lock.Unlock()
+	for name := range sd.derived {
+		if value, cmsg, ok := sd.inspect(name); ok && cmsg == nil && sd.legacy[name] == false {
+			cs = append(cs, name, value)
+		}
+	}
 	return cs
 }
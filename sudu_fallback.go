@@ -0,0 +1,109 @@
+package sudu
+
+import (
+	"fmt"
+)
+
+// 部分条件以缓存中legacy的值降级确认，而不是由任务重新计算得出
+// Wait在其余一切正常时返回此错误
+type DegradedError struct {
+	Names []interface{}
+	// 第一个被降级掩盖的任务错误
+	Err error
+}
+
+func (e *DegradedError) Error() string {
+	return fmt.Sprintf("degraded conditions %v: %v", e.Names, e.Err)
+}
+
+func (e *DegradedError) Unwrap() error {
+	return e.Err
+}
+
+// 允许names在任务确认失败时降级，使用cache还原的legacy的值
+// 降级的条件被标记为stale，下游任务照常执行，但此次运行的结果不会保存到cache
+// stale的条件可由Stale、Unconfirmed以及ConditionEvent、ConditionRecord的Stale字段查询
+// 任务的输出依据上一次运行的记录（随cache一起保存）判断，没有记录时无法降级
+func (sd *Sudu) Fallback(names ...interface{}) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	if sd.fallbacks == nil {
+		sd.fallbacks = make(map[interface{}]bool)
+	}
+	for _, name := range names {
+		sd.fallbacks[name] = true
+	}
+}
+
+// 降级确认的条件
+func (sd *Sudu) Degraded() []interface{} {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	names := make([]interface{}, len(sd.degraded))
+	copy(names, sd.degraded)
+	return names
+}
+
+// 任务尚未写入的输出，全部允许降级时返回它们
+func (sd *Sudu) fallback(t *task) []interface{} {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	p := sd.profiles[t.id]
+	if p == nil || len(sd.fallbacks) == 0 {
+		return nil
+	}
+
+	t.fx_lock.Lock()
+	defer t.fx_lock.Unlock()
+
+	names := make([]interface{}, 0, len(p.Writes))
+	for _, name := range p.Writes {
+		if _, ok := t.w_values[name]; ok {
+			continue
+		}
+		if sd.fallbacks[name] == false {
+			return nil
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// 调用方需持有sd.lock
+func (sd *Sudu) degrade(t *task) {
+	for _, name := range t.stale {
+		found := false
+		for _, n := range sd.degraded {
+			if n == name {
+				found = true
+				break
+			}
+		}
+		if found == false {
+			sd.degraded = append(sd.degraded, name)
+		}
+	}
+	if sd.degrade_err == nil {
+		sd.degrade_err = settledError(t.fx_panic)
+	}
+}
+
+// 运行的结果，没有失败但存在降级时返回*DegradedError
+func (sd *Sudu) result(p interface{}) error {
+	if p != nil {
+		return panicError(p)
+	}
+
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	if len(sd.degraded) == 0 {
+		return nil
+	}
+	return &DegradedError{
+		Names: append([]interface{}(nil), sd.degraded...),
+		Err:   sd.degrade_err,
+	}
+}
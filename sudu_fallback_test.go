@@ -0,0 +1,56 @@
+package sudu
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSuduFallback(t *testing.T) {
+	cache := NewCache("fallback", fmt.Sprintf("%d", time.Now().UnixNano()))
+	fail := errors.New("fail")
+
+	run := func(broken bool) (*Sudu, error) {
+		sd := NewSudu(cache)
+		sd.SetHistory(4)
+		sd.Fallback("B")
+		sd.Go(func(cg *ConditionGroup) {
+			a := cg.Require("A").(int)
+			if broken {
+				panic(fail)
+			}
+			cg.Satisfy("B", a*2)
+		})
+		sd.Go(func(cg *ConditionGroup) {
+			cg.Satisfy("C", cg.Require("B").(int)+1)
+		})
+		sd.Satisfy("A", 1)
+		return sd, sd.Wait()
+	}
+
+	if _, err := run(false); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	sd, err := run(true)
+	var degraded *DegradedError
+	if errors.As(err, &degraded) == false || errors.Is(err, fail) == false {
+		t.Fatalf("expect degraded error, got %v", err)
+	}
+	if len(degraded.Names) != 1 || degraded.Names[0] != "B" {
+		t.Errorf("degraded = %v, expect [B]", degraded.Names)
+	}
+	if c := sd.Require("C").(int); c != 3 {
+		t.Errorf("C = %d, expect %d", c, 3)
+	}
+	if sd.Stale("B") == false || sd.Stale("C") {
+		t.Errorf("expect only B stale")
+	}
+	if names := sd.Unconfirmed(); len(names) != 1 || names[0] != "B" {
+		t.Errorf("unconfirmed = %v, expect [B]", names)
+	}
+	if records := sd.History("B"); len(records) == 0 || records[len(records)-1].Stale == false {
+		t.Errorf("expect stale record of B, got %v", records)
+	}
+}
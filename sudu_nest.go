@@ -0,0 +1,82 @@
+package sudu
+
+// 将child作为sd的一个任务运行
+// 每轮从sd读取inputs写入child，等待child完成后，再将outputs写回sd
+// sd中legacy的输入，在child中也是legacy的，child的任务因此只做预测，输出同样是legacy的
+// sd中的输入得到确认后，child中对应的输入随之确认，进而确认child的全部任务
+// child出错时，此任务以同样的错误失败
+// child在legacy的输入上失败时，仍被视为完成了一轮，待输入确认后child的失败才被确认
+// 此时此任务已经结束，由child通知此任务重做，从而以child的错误失败
+func (sd *Sudu) Nest(child *Sudu, inputs, outputs []interface{}) {
+	concerned := make(map[interface{}]bool, len(inputs))
+	for _, name := range inputs {
+		concerned[name] = true
+	}
+
+	sd.lock.Lock()
+	sd.children = append(sd.children, child)
+	sd.lock.Unlock()
+
+	cg := &sd.ConditionGroup
+	cg.lock.Lock()
+	cg.listenWriteEvent(func(names ...interface{}) {
+		confirmed := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			if concerned[name] {
+				confirmed = append(confirmed, name)
+			}
+		}
+		if len(confirmed) > 0 {
+			child.relay(cg, confirmed...)
+		}
+	})
+	cg.lock.Unlock()
+
+	if sd.leader != nil {
+		return
+	}
+
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	id := sd.task(func(cg *ConditionGroup) {
+		values, cmsgs := cg.WantAll(inputs...)
+
+		cg.lock.Lock()
+		legacy := make([]bool, len(inputs))
+		for i, name := range inputs {
+			legacy[i] = cg.legacy[name]
+		}
+		cg.lock.Unlock()
+
+		for i, name := range inputs {
+			if cmsgs[i] != nil {
+				child.Cancel(name, cmsgs[i])
+			} else if legacy[i] {
+				child.SatisfyLegacy(name, values[i])
+			} else {
+				child.Satisfy(name, values[i])
+			}
+		}
+
+		if err := child.Wait(); err != nil {
+			panic(err)
+		}
+
+		for _, name := range outputs {
+			v, cmsg, ok := child.Inspect(name)
+			if ok == false {
+				continue
+			}
+			if cmsg == nil {
+				cg.Satisfy(name, v)
+			} else {
+				cg.Cancel(name, cmsg)
+			}
+		}
+	}, TaskOptions{})
+
+	t := sd.tasks[id]
+	child.lock.Lock()
+	child.nested = t.invalidate
+	child.lock.Unlock()
+}
@@ -0,0 +1,78 @@
+package sudu
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSuduNest(t *testing.T) {
+	child := NewSudu(nil)
+	var cnt int
+	child.Go(func(cg *ConditionGroup) {
+		cnt++
+		cg.Satisfy("Y", cg.Require("X").(int)*10)
+	})
+
+	sd := NewSudu(nil)
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("X", cg.Require("A").(int)+1)
+	})
+	sd.Nest(child, []interface{}{"X"}, []interface{}{"Y"})
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("Z", cg.Require("Y").(int)+1)
+	})
+
+	// 预测正确，child只执行一次，由父级的确认完成确认
+	sd.SatisfyLegacy("X", 2)
+	sd.Satisfy("A", 1)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if z := sd.Require("Z").(int); z != 21 || cnt != 1 {
+		t.Errorf("Z = %d, cnt = %d, expect %d, %d", z, cnt, 21, 1)
+	}
+	if len(sd.Unconfirmed()) != 0 || len(child.Unconfirmed()) != 0 {
+		t.Errorf("expect all confirmed, got %v, %v", sd.Unconfirmed(), child.Unconfirmed())
+	}
+
+	sd.Satisfy("A", 2)
+	sd.Wait()
+	if z := sd.Require("Z").(int); z != 31 || cnt != 2 {
+		t.Errorf("Z = %d, cnt = %d, expect %d, %d", z, cnt, 31, 2)
+	}
+}
+
+func TestSuduNestLegacyFailure(t *testing.T) {
+	boom := errors.New("boom")
+	child := NewSudu(nil)
+	child.Go(func(cg *ConditionGroup) {
+		cg.Require("X")
+		panic(boom)
+	})
+
+	sd := NewSudu(nil)
+	sd.Nest(child, []interface{}{"X"}, []interface{}{"Y"})
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("Z", cg.Require("Y"))
+	})
+
+	// child在legacy的输入上失败，输入确认后，失败需要传回父级
+	sd.SatisfyLegacy("X", 1)
+	time.Sleep(10 * time.Millisecond)
+	sd.Satisfy("X", 1)
+
+	done := make(chan error)
+	go func() {
+		done <- sd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if errors.Is(err, boom) == false {
+			t.Errorf("Wait = %v, expect child failure", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("parent blocked by unreported child failure")
+	}
+	sd.Close()
+}
@@ -0,0 +1,128 @@
+package sudu
+
+import (
+	"context"
+)
+
+// 常驻的响应式运行模式，类似电子表格的增量计算
+// 外部持续通过Satisfy更新输入，只有受影响的任务会重新计算
+// 每当所有任务都静止下来，settled被调用一次，err为此期间第一个失败任务的错误
+// 任务的失败不会终止运行，后续的输入更新依然会触发重算，直到Close
+// 每次静止且没有错误时，都会保存一次cache
+func (sd *Sudu) React(settled func(err error)) {
+	q := newQueue()
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sd.lock.Lock()
+	if sd.reacting != nil || sd.closed {
+		sd.lock.Unlock()
+		cancel()
+		return
+	}
+	// 不会因为任务的失败而禁止后续的重做
+	sd.WaitAll = true
+	sd.reacting = q
+	sd.reacted = done
+	sd.unreact = cancel
+	if sd.Tasks > 0 && sd.Running == 0 {
+		sd.quiesce()
+	}
+	sd.lock.Unlock()
+
+	go func() {
+		defer close(done)
+		for {
+			items, ok := q.take(ctx)
+			if ok == false {
+				return
+			}
+			for _, item := range items {
+				p := item.(*settlement).fx_panic
+				if p == nil {
+					sd.cacheSave()
+				}
+				if settled != nil {
+					settled(settledError(p))
+				}
+			}
+		}
+	}()
+}
+
+// 中止运行，效果与Close相同，但不等待任务结束
+// Wait将返回以reason构造的*CancelMessage，即使此前已有任务失败
+func (sd *Sudu) Abort(reason interface{}) {
+	sd.halt(newCancelMessage(nil, reason), true)
+}
+
+func (sd *Sudu) halt(cmsg *CancelMessage, abort bool) {
+	sd.lock.Lock()
+	if sd.closed {
+		sd.lock.Unlock()
+		return
+	}
+	sd.closed = true
+	children := sd.children
+	if abort {
+		if sd.fx_panic == nil && sd.WaitAll == false {
+			sd.wg.Add(sd.Running * -1)
+		}
+		sd.fx_panic = cmsg
+	}
+	sd.land()
+	sd.lock.Unlock()
+
+	// 之后的写入事件不会再触发任何重做，此前触发的重做也都已经登记在alive中
+	sd.cancelAll(cmsg)
+	for _, child := range children {
+		child.halt(cmsg, abort)
+	}
+}
+
+func (sd *Sudu) halted() bool {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+
+	return sd.closed
+}
+
+type settlement struct {
+	fx_panic interface{}
+}
+
+// 所有任务都静止了，调用方需持有sd.lock
+func (sd *Sudu) quiesce() {
+	if sd.reacting == nil {
+		return
+	}
+	sd.reacting.push(&settlement{sd.fx_panic})
+	sd.fx_panic = nil
+}
+
+// 停止所有任务，不再开始任何新的一轮或重做，排队等待执行名额的任务也不再执行
+// 阻塞在Want中的任务会被唤醒，Close在此Sudu（以及Nest的子Sudu）启动的所有goroutine退出后返回
+// 即使Wait已经因为任务失败而提前返回，Close也会等待其余仍在运行的任务
+// 常驻模式下，已经产生的settled通知会在Close返回前全部处理完
+func (sd *Sudu) Close() {
+	sd.halt(newCancelMessage(nil, "closed"), false)
+
+	sd.lock.Lock()
+	children := sd.children
+	sd.lock.Unlock()
+	for _, child := range children {
+		child.Close()
+	}
+	sd.wg.Wait()
+	sd.alive.Wait()
+
+	sd.lock.Lock()
+	sd.land()
+	done, unreact := sd.reacted, sd.unreact
+	sd.lock.Unlock()
+
+	if done != nil {
+		unreact()
+		<-done
+	}
+}
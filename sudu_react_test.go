@@ -0,0 +1,63 @@
+package sudu
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSuduReact(t *testing.T) {
+	sd := NewSudu(NewCache("react", "config"))
+
+	var cntb, cntc int
+	sd.Go(func(cg *ConditionGroup) {
+		cntb++
+		a := cg.Require("A").(int)
+		if a < 0 {
+			panic(errors.New("negative"))
+		}
+		cg.Satisfy("B", a*2)
+	})
+	sd.Go(func(cg *ConditionGroup) {
+		cntc++
+		cg.Satisfy("C", cg.Require("X").(int)+1)
+	})
+
+	settled := make(chan error, 16)
+	sd.React(func(err error) {
+		settled <- err
+	})
+
+	sd.Satisfy("A", 1, "X", 1)
+	if err := <-settled; err != nil || sd.Require("B").(int) != 2 {
+		t.Errorf("err = %v, B = %v", err, sd.Require("B"))
+	}
+
+	// 只有受影响的任务会重算
+	sd.Satisfy("A", 2)
+	if err := <-settled; err != nil || sd.Require("B").(int) != 4 || cntb != 2 || cntc != 1 {
+		t.Errorf("err = %v, B = %v, cntb = %d, cntc = %d", err, sd.Require("B"), cntb, cntc)
+	}
+
+	// 失败不会终止运行
+	sd.Satisfy("A", -1)
+	if err := <-settled; err == nil || err.Error() != "negative" {
+		t.Errorf("err = %v, expect negative", err)
+	}
+	sd.Satisfy("A", 3)
+	if err := <-settled; err != nil || sd.Require("B").(int) != 6 {
+		t.Errorf("err = %v, B = %v", err, sd.Require("B"))
+	}
+
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Require("never")
+	})
+	sd.Close()
+
+	sd.Satisfy("A", 4)
+	if cntb != 4 || sd.Running != 0 {
+		t.Errorf("cntb = %d, running = %d, expect stopped", cntb, sd.Running)
+	}
+	if len(settled) != 0 {
+		t.Errorf("unexpected settlement after close")
+	}
+}
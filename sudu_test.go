@@ -1,7 +1,9 @@
 package sudu
 
 import (
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -140,3 +142,367 @@ func TestSudu03(t *testing.T) {
 		}
 	}
 }
+
+func TestSuduShared(t *testing.T) {
+	cache := NewCache("metadata", "shared")
+	cache.Shared = true
+
+	var cnt int32
+	build := func() *Sudu {
+		sd := NewSudu(cache)
+		sd.Go(func(cg *ConditionGroup) {
+			atomic.AddInt32(&cnt, 1)
+			a := cg.Require("A").(int)
+			time.Sleep(5 * time.Millisecond)
+			cg.Satisfy("B", a*2)
+		})
+		return sd
+	}
+
+	sd1 := build()
+	sd2 := build()
+	if sd2.leader != sd1 {
+		t.Fatalf("expect sd2 follow sd1")
+	}
+
+	go func() {
+		sd1.Satisfy("A", 3)
+		sd1.Wait()
+	}()
+	if err := sd2.Wait(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if b := sd2.Require("B").(int); b != 6 {
+		t.Errorf("B = %d, expect %d", b, 6)
+	}
+	if atomic.LoadInt32(&cnt) != 1 {
+		t.Errorf("cnt = %d, expect %d", cnt, 1)
+	}
+
+	// 先行者结束后，相同Key重新开始执行
+	sd3 := build()
+	if sd3.leader != nil {
+		t.Errorf("expect sd3 to lead")
+	}
+	sd3.Satisfy("A", 4)
+	sd3.Wait()
+	if atomic.LoadInt32(&cnt) != 2 {
+		t.Errorf("cnt = %d, expect %d", cnt, 2)
+	}
+}
+
+func TestSuduWaitLegacy(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.WaitLegacy = true
+
+	settled := make(chan error, 1)
+	sd.Settled = func(err error) {
+		settled <- err
+	}
+
+	chain := func(from, to string) {
+		sd.Go(func(cg *ConditionGroup) {
+			v := cg.Require(from).(int)
+			time.Sleep(10 * time.Millisecond)
+			cg.Satisfy(to, v+1)
+		})
+	}
+	chain("A", "B")
+	chain("B", "C")
+	chain("C", "D")
+
+	sd.SatisfyLegacy("B", 0, "C", 0, "D", 0)
+	sd.Satisfy("A", 1)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	found := false
+	for _, name := range sd.Unconfirmed() {
+		if name == "D" {
+			found = true
+		}
+	}
+	if found == false {
+		t.Errorf("expect D to be unconfirmed")
+	}
+
+	if err := <-settled; err != nil {
+		t.Errorf("unexpected settled error %v", err)
+	}
+	if d := sd.Require("D").(int); d != 4 {
+		t.Errorf("D = %d, expect %d", d, 4)
+	}
+	if len(sd.Unconfirmed()) != 0 {
+		t.Errorf("expect all confirmed, got %v", sd.Unconfirmed())
+	}
+}
+
+func TestSuduRetract(t *testing.T) {
+	sd := NewSudu(nil)
+
+	var cnt int
+	sd.Go(func(cg *ConditionGroup) {
+		cnt++
+		a := cg.Require("A").(int)
+		cg.Satisfy("B", a*2)
+	})
+
+	sd.Satisfy("A", 1)
+	sd.Wait()
+
+	sd.Retract("A")
+	sd.Wait()
+	if cnt != 1 || sd.Running != 0 {
+		t.Errorf("cnt = %d, running = %d, expect no redo before satisfied", cnt, sd.Running)
+	}
+
+	sd.Satisfy("A", 5)
+	sd.Wait()
+	if cnt != 2 || sd.Require("B").(int) != 10 {
+		t.Errorf("cnt = %d, B = %v, expect redo", cnt, sd.Require("B"))
+	}
+}
+
+func TestSuduWantAll(t *testing.T) {
+	sd := NewSudu(nil)
+
+	var cnt int
+	sd.Go(func(cg *ConditionGroup) {
+		cnt++
+		values, _ := cg.WantAll("A", "B")
+		cg.Satisfy("C", values[0].(int)+values[1].(int))
+	})
+
+	sd.SatisfyLegacy("B", 1)
+	sd.Satisfy("A", 1)
+	sd.Wait()
+	if cnt != 1 {
+		t.Errorf("cnt = %d, expect %d", cnt, 1)
+	}
+
+	sd.Satisfy("B", 2)
+	sd.Wait()
+	if cnt != 2 || sd.Require("C").(int) != 3 {
+		t.Errorf("cnt = %d, C = %v, expect redo on B", cnt, sd.Require("C"))
+	}
+	if len(sd.Unconfirmed()) != 0 {
+		t.Errorf("expect all confirmed, got %v", sd.Unconfirmed())
+	}
+}
+
+func TestSuduCancelError(t *testing.T) {
+	sd := NewSudu(nil)
+
+	sd.Go(func(cg *ConditionGroup) {
+		if _, cmsg := cg.Want("A"); cmsg != nil {
+			cg.Cancel("B", cmsg)
+			return
+		}
+		cg.Satisfy("B", 1)
+	})
+	sd.Go(func(cg *ConditionGroup) {
+		cg.Satisfy("C", cg.Require("B"))
+	})
+
+	root := errors.New("root")
+	sd.Cancel("A", root)
+	err := sd.Wait()
+
+	var cmsg *CancelMessage
+	if errors.As(err, &cmsg) == false {
+		t.Fatalf("expect *CancelMessage, got %v", err)
+	}
+	if errors.Is(err, root) == false {
+		t.Errorf("expect %v to wrap root", err)
+	}
+	path := cmsg.Path()
+	if len(path) != 2 || path[0] != "B" || path[1] != "A" {
+		t.Errorf("path = %v, expect [B A]", path)
+	}
+}
+
+func TestSuduAbort(t *testing.T) {
+	sd := NewSudu(nil)
+
+	var cnt int32
+	sd.Go(func(cg *ConditionGroup) {
+		atomic.AddInt32(&cnt, 1)
+		cg.Require("A")
+	})
+
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		sd.Abort("stop")
+	}()
+	err := sd.Wait()
+
+	var cmsg *CancelMessage
+	if errors.As(err, &cmsg) == false || cmsg.Message != "stop" {
+		t.Fatalf("expect abort reason, got %v", err)
+	}
+
+	sd.Satisfy("A", 1)
+	sd.Close()
+	if n := atomic.LoadInt32(&cnt); n != 1 {
+		t.Errorf("cnt = %d, expect no redo after abort", n)
+	}
+}
+
+func TestSuduAbortQueued(t *testing.T) {
+	sd := NewSudu(nil)
+	sd.SetLimit(1)
+
+	var cnt int32
+	busy := func(cg *ConditionGroup) {
+		atomic.AddInt32(&cnt, 1)
+		time.Sleep(20 * time.Millisecond)
+	}
+	sd.Go(busy, busy, busy, busy)
+
+	// 排队等待名额的任务，在Abort之后不再执行
+	time.Sleep(5 * time.Millisecond)
+	sd.Abort("stop")
+	sd.Close()
+	if n := atomic.LoadInt32(&cnt); n != 1 {
+		t.Errorf("cnt = %d, expect queued tasks skipped after abort", n)
+	}
+}
+
+func TestSuduCloseDrain(t *testing.T) {
+	sd := NewSudu(nil)
+
+	var exited int32
+	sd.Go(func(cg *ConditionGroup) {
+		defer atomic.AddInt32(&exited, 1)
+		cg.Want("X")
+	})
+	sd.Go(func(cg *ConditionGroup) {
+		time.Sleep(5 * time.Millisecond)
+		panic(fmt.Errorf("fail"))
+	})
+
+	if err := sd.Wait(); err == nil {
+		t.Fatalf("expect error")
+	}
+	sd.Close()
+	if n := atomic.LoadInt32(&exited); n != 1 {
+		t.Errorf("exited = %d, expect parked task to exit before Close returns", n)
+	}
+}
+
+func TestSuduFanOut(t *testing.T) {
+	sd := NewSudu(nil)
+
+	sd.Go(func(cg *ConditionGroup) {
+		n := cg.Require("N").(int)
+		for i := 0; i < n; i++ {
+			i := i
+			sd.Go(func(cg *ConditionGroup) {
+				time.Sleep(time.Millisecond)
+				cg.Satisfy(i, i*i)
+			})
+		}
+	})
+
+	sd.Satisfy("N", 3)
+	if err := sd.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if v, _, ok := sd.Inspect(i); ok == false || v.(int) != i*i {
+			t.Errorf("%d = %v, expect %d", i, v, i*i)
+		}
+	}
+}
+
+func TestSuduSharedAbandoned(t *testing.T) {
+	cache := NewCache("metadata", fmt.Sprintf("abandoned-%d", time.Now().UnixNano()))
+	cache.Shared = true
+
+	build := func() *Sudu {
+		sd := NewSudu(cache)
+		sd.Go(func(cg *ConditionGroup) {
+			cg.Satisfy("B", cg.Require("A").(int)*2)
+		})
+		return sd
+	}
+
+	// 先行者的调用方从不调用Wait
+	sd1 := build()
+	sd2 := build()
+	sd1.Satisfy("A", 3)
+
+	done := make(chan error)
+	go func() {
+		done <- sd2.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil || sd2.Require("B").(int) != 6 {
+			t.Errorf("err = %v, B = %v", err, sd2.Require("B"))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("follower blocked by abandoned leader")
+	}
+
+	if sd3 := build(); sd3.leader != nil {
+		t.Errorf("expect abandoned leader to leave the registry")
+	}
+}
+
+func TestSuduSharedLegacy(t *testing.T) {
+	cache := NewCache("metadata", fmt.Sprintf("shared-legacy-%d", time.Now().UnixNano()))
+
+	build := func() *Sudu {
+		sd := NewSudu(cache)
+		sd.Go(func(cg *ConditionGroup) {
+			cg.Satisfy("B", cg.Require("A").(int)*2)
+		})
+		return sd
+	}
+
+	// cache中已有A、B，先行者仅凭legacy的值就能完成一轮，但此时不能结束
+	cache.Set([]interface{}{"A", 1, "B", 2})
+	cache.Shared = true
+	sd1 := build()
+	sd2 := build()
+
+	done := make(chan error)
+	go func() {
+		done <- sd2.Wait()
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("follower returned %v before the leader got its inputs", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if sd3 := build(); sd3.leader != sd1 {
+		t.Errorf("expect sd3 to follow sd1")
+	}
+
+	sd1.Satisfy("A", 5)
+	if err := <-done; err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if b, _, _ := sd2.Inspect("B"); b != 10 {
+		t.Errorf("B = %v, expect %d", b, 10)
+	}
+	sd1.Wait()
+}
+
+func TestSuduSharedCancelCause(t *testing.T) {
+	cache := NewCache("metadata", fmt.Sprintf("cause-%d", time.Now().UnixNano()))
+	cache.Shared = true
+
+	sd1 := NewSudu(cache)
+	sd2 := NewSudu(cache)
+
+	root := errors.New("root")
+	sd1.Cancel("A", root)
+	_, cmsg := sd2.Want("A")
+	if cmsg == nil || errors.Is(cmsg, root) == false {
+		t.Errorf("cmsg = %v, expect follower to keep the cause", cmsg)
+	}
+	sd1.Close()
+}
@@ -2,8 +2,27 @@ package sudu
 
 import (
 	"sync"
+	"time"
 )
 
+// 任务的可选配置
+type TaskOptions struct {
+	// 执行名额不足时，优先级高的任务先执行
+	Priority int
+	// 失败后的重试策略，nil表示不重试
+	Retry *RetryPolicy
+	// 对冲执行的策略，nil表示不对冲
+	Hedge *HedgePolicy
+	// 执行时需要占用的命名资源，参见Resources
+	Resources []string
+	// 任务是纯粹由读到的条件决定写入的，读到的条件与上一次运行相同时，直接重放写入
+	// 需要cache保存运行记录，只对Sudu有效
+	Memo bool
+	// 任务的标识，用于找到上一次运行中同一个任务的记录，各任务之间不可重复
+	// 为nil时依据Go的顺序以及任务本体的函数来判断，任务的增减或者调整顺序都会使记录失效
+	MemoKey interface{}
+}
+
 type task struct {
 	id      int
 	origin  *ConditionGroup
@@ -20,6 +39,8 @@ type task struct {
 	cg *ConditionGroup
 	// 追踪，此任务执行过程中，全部require或want的条件
 	r_values map[interface{}]*cValue
+	// r_values中的条件按第一次读到的顺序排列
+	reads []interface{}
 	// 追踪，此任务明确require或want的条件，在执行过程中，却产生了变更，则记录在此
 	// 用于和r_values比对，确认是否需要重做任务
 	rw_values map[interface{}]*cValue
@@ -33,11 +54,42 @@ type task struct {
 	legacy_mode bool
 	// 标记此任务被禁止，不再有被执行的必要了
 	disabled bool
+	// 任务的结果依赖于条件之外的状态，并且该状态已经变更，参见invalidate
+	dirty bool
 
 	// 任务执行过程中panic的值
 	// 如果数据类型为error，则认为是预期内的终止信号
 	// 但是否终止，同样取决于此时任务是否处于unlegacy状态下
 	fx_panic interface{}
+
+	// 执行名额，阻塞在Want中时让出名额，名额不足时按优先级以及关键路径的长度排队
+	gate     *scheduler
+	priority int
+	weight   time.Duration
+	retry    *RetryPolicy
+	hedge    *HedgePolicy
+	// 与gate一样，阻塞在Want中时让出
+	pool      *Resources
+	resources []string
+
+	// 上一次运行的记录，非nil时先尝试重放，参见TaskOptions.Memo
+	memo     *taskMemo
+	memo_key interface{}
+	memoize  bool
+	replayed bool
+
+	// 确认的失败时，返回需要以legacy的值降级确认的输出，nil表示无法降级
+	fallback func(*task) []interface{}
+	// 本轮降级确认的输出
+	stale []interface{}
+	// 本轮实际占用名额执行的时间，不包含阻塞在Want中的时间
+	elapsed time.Duration
+	entered time.Time
+
+	// 此任务启动的所有goroutine，用于确认任务已经彻底退出
+	alive *sync.WaitGroup
+	// 所属的Sudu已经Close或Abort，排队得到名额之后不再执行任务本体
+	halted func() bool
 }
 
 // 临时记录每个任务内部读到的条件及当时的值 & 后续期间监测到这些条件的变化
@@ -66,6 +118,9 @@ const (
 	task_state_fail_legacy    = 3
 	task_state_success        = 4
 	task_state_fail           = 5
+	task_state_retry          = 6
+	// 失败但输出以legacy的值降级确认，对外等同于成功
+	task_state_degraded = 7
 )
 
 func (t *task) notify(state int, redo bool) bool {
@@ -82,19 +137,139 @@ func (t *task) notify(state int, redo bool) bool {
 
 func (t *task) reset() {
 	t.r_values = make(map[interface{}]*cValue)
+	t.reads = nil
 	t.rw_values = make(map[interface{}]*cValue)
 	t.w_values = make(map[interface{}]*cValue)
 	t.legacy_mode = false
 	t.fx_panic = nil
 	t.disabled = false
+	t.dirty = false
+	t.elapsed = 0
+	t.stale = nil
+	t.replayed = false
 
 	t.cg = t.origin.clone()
+	t.cg.writer = t.id
 	t.cg.listenReadEvent(t.listenLocalRead)
 	t.cg.listenWriteEvent(t.listenLocalWrite)
+	t.cg.listenParkEvent(t.listenLocalPark)
+}
+
+// 启动一个被追踪的goroutine，Add总是发生在调用方所在的goroutine中
+func (t *task) spawn(fx func()) {
+	if t.alive != nil {
+		t.alive.Add(1)
+	}
+	go func() {
+		if t.alive != nil {
+			defer t.alive.Done()
+		}
+		fx()
+	}()
+}
+
+// 对冲执行的任务由每次执行分别占用名额，参见hedged
+func (t *task) enter() {
+	if t.hedge == nil {
+		t.acquire()
+	}
+	t.entered = time.Now()
+}
+
+func (t *task) leave() {
+	t.elapsed += time.Since(t.entered)
+	if t.hedge == nil {
+		t.release()
+	}
+}
+
+// 占用并发限制以及资源的名额
+func (t *task) acquire() {
+	if t.gate != nil {
+		t.gate.acquire(t.priority, t.weight)
+	}
+	if len(t.resources) > 0 {
+		t.pool.acquire(t.resources, t.priority, t.weight)
+	}
+}
+
+func (t *task) release() {
+	if len(t.resources) > 0 {
+		t.pool.release(t.resources)
+	}
+	if t.gate != nil {
+		t.gate.release()
+	}
 }
 
 func (t *task) core() {
+	for attempt := 1; t.run() == false; attempt++ {
+		if t.wait_retry(attempt) == false {
+			break
+		}
+	}
+	t.finish()
+}
+
+// legacy的失败在条件确认后（值未变）成为确认的失败，将其作为第一次执行的失败进行重试
+func (t *task) retry_legacy() {
+	for attempt := 1; t.wait_retry(attempt); attempt++ {
+		if t.run() {
+			break
+		}
+	}
+	t.finish()
+}
+
+func (t *task) finish() {
+	if t.fx_panic != nil && t.legacy_mode == false {
+		t.degrade(false)
+	}
+
+	// 检查一下依赖的条件是否存在值或者状态变更的情况
+	// 如果有，则意味着此任务应当要重做
+	t.redo_done()
+}
+
+// 确认的失败，尝试将尚未写入的输出以其legacy的值确认下来，使下游可以继续
+// 只有全部缺失的输出都允许降级，并且都存在legacy的值时才会降级
+func (t *task) degrade(locked bool) bool {
+	if t.fallback == nil {
+		return false
+	}
+	names := t.fallback(t)
+	if len(names) == 0 {
+		return false
+	}
+
+	if locked == false {
+		t.cg.lock.Lock()
+		defer t.cg.lock.Unlock()
+	}
+
+	nvs := make([]interface{}, 0, len(names)*2)
+	for _, name := range names {
+		value, ok := t.cg.raw[name]
+		if ok == false || t.cg.legacy[name] == false {
+			return false
+		}
+		nvs = append(nvs, name, value)
+	}
+	t.cg.write(false, false, true, nvs...)
+	t.stale = names
+	return true
+}
+
+// 排队等待名额期间Sudu可能已经停止，此时以取消结束本轮
+func (t *task) check_halted() {
+	if t.halted != nil && t.halted() {
+		panic(newCancelMessage(nil, "halted"))
+	}
+}
+
+func (t *task) run() (ok bool) {
 	t.reset()
+	t.enter()
 
 	defer func() {
 		// 避免任务自身的panic导致意外发生
@@ -102,18 +277,83 @@ func (t *task) core() {
 		// 在明确此任务的运行依赖条件都为unlegacy的状态时
 		// 那么此error即表明任务的运行结果的确是出错了
 		t.fx_panic = recover()
-
-		// 检查一下依赖的条件是否存在值或者状态变更的情况
-		// 如果有，则意味着此任务应当要重做
-		t.redo_done()
+		t.leave()
+		ok = t.fx_panic == nil
 	}()
 
-	t.fx(t.cg)
+	t.check_halted()
+	if t.replay() {
+		return
+	}
+	if t.hedge != nil {
+		t.hedged()
+	} else {
+		t.fx(t.cg)
+	}
+	return
+}
+
+// 每次执行都使用暂存写入的clone，只提交胜出的一次
+// 每次执行各自占用执行名额以及资源，阻塞在Want中时各自让出
+func (t *task) hedged() {
+	cgs := []*ConditionGroup{t.cg.buffered(), t.cg.buffered()}
+	for _, cg := range cgs {
+		// 每次执行各自在阻塞等待条件期间让出名额
+		cg.listenParkEvent(func(parked bool) {
+			if parked {
+				t.release()
+			} else {
+				t.acquire()
+			}
+		})
+	}
+	winner, p := t.hedge.race(t.spawn, func(i int) interface{} {
+		// 落败的一次仍在执行，直到结束前都占用着自己的名额
+		t.acquire()
+		defer t.release()
+		return try(func() {
+			t.check_halted()
+			t.fx(cgs[i])
+		})
+	})
+
+	for i, cg := range cgs {
+		if i != winner {
+			cg.detach()
+		}
+	}
+	if p != nil {
+		panic(p)
+	}
+	cgs[winner].commit(t.cg)
+}
+
+// 确认的失败，按照重试策略等待后重新执行，返回false则以失败结束本轮
+// 依赖的条件已经变更时不重试，交由redo处理
+func (t *task) wait_retry(attempt int) bool {
+	if t.legacy_mode {
+		return false
+	}
+
+	t.fx_lock.Lock()
+	redo := t.changed()
+	t.fx_lock.Unlock()
+	if redo {
+		return false
+	}
+
+	backoff, ok := t.retry.next(attempt, t.fx_panic)
+	if ok == false || t.notify(task_state_retry, false) == false {
+		return false
+	}
+	t.retry.retried(attempt, t.fx_panic)
+	time.Sleep(backoff)
+	return true
 }
 
 // notify the task state & redo flag, and the returned true control it continue
 func (t *task) do(notify func(int, bool) bool) {
-	go func() {
+	t.spawn(func() {
 		t.do_lock.Lock()
 		defer t.do_lock.Unlock()
 		if t.doing {
@@ -126,9 +366,9 @@ func (t *task) do(notify func(int, bool) bool) {
 		if t.notify(task_state_start, false) {
 			t.doing = true
 			// 状态通知后，没什么意外就会正式开始执行任务
-			go t.core()
+			t.spawn(t.core)
 		}
-	}()
+	})
 }
 
 // 任务正常执行完成后，redo_done用于触发下一次可能的redo
@@ -149,6 +389,8 @@ func (t *task) redo_done() {
 	} else {
 		if t.fx_panic == nil {
 			state = task_state_success
+		} else if t.stale != nil {
+			state = task_state_degraded
 		} else {
 			state = task_state_fail
 		}
@@ -168,9 +410,9 @@ func (t *task) redo_done() {
 	}
 
 	if redo {
-		go t.core()
+		t.spawn(t.core)
 	} else {
-		t.try_unlegacy()
+		t.try_unlegacy(false)
 	}
 }
 
@@ -191,14 +433,24 @@ func (t *task) redo_write() {
 	if redo {
 		if t.notify(task_state_start, true) == true {
 			t.doing = true
-			go t.core()
+			t.spawn(t.core)
 		}
 	} else {
-		t.try_unlegacy()
+		t.try_unlegacy(true)
 	}
 }
 
-func (t *task) try_unlegacy() {
+// 任务读到的条件没有变更，但其结果依赖的外部状态发生了变更（例如Nest中child确认的失败）
+// 正在执行的任务在结束后重做，已经结束的任务立即重做
+func (t *task) invalidate() {
+	t.fx_lock.Lock()
+	t.dirty = true
+	t.fx_lock.Unlock()
+	t.redo_write()
+}
+
+// locked表示调用方是否已经持有条件的锁（由写入事件触发）
+func (t *task) try_unlegacy(locked bool) {
 	if t.legacy_mode {
 		// check legacy
 		// 虽然值未改变，但是其legacy状态可能全部被移除
@@ -215,11 +467,15 @@ func (t *task) try_unlegacy() {
 		t.legacy_mode = false
 		t.cg.legacy_mode = false
 
-		// 没有start状态，只用于报告转变
-		if t.fx_panic == nil {
-			t.notify(task_state_success, false)
-		} else {
-			t.notify(task_state_fail, false)
+		// 失败可以重试时，不报告失败，直接开始重试
+		if _, ok := t.retry.next(1, t.fx_panic); ok && t.fx_panic != nil {
+			t.doing = true
+			if t.notify(task_state_start, true) {
+				t.spawn(t.retry_legacy)
+			} else {
+				t.doing = false
+			}
+			return
 		}
 
 		// 翻转条件状态，通知条件链上关联的全部条件
@@ -240,6 +496,16 @@ func (t *task) try_unlegacy() {
 		if len(nvs2) > 0 {
 			t.cg.satisfy(true, false, nvs2...)
 		}
+
+		// 没有start状态，只用于报告转变
+		// 在输出翻转之后报告，此时下游（包括跟随者）已经看到确认的值
+		if t.fx_panic == nil {
+			t.notify(task_state_success, false)
+		} else if t.degrade(locked) {
+			t.notify(task_state_degraded, false)
+		} else {
+			t.notify(task_state_fail, false)
+		}
 	}
 }
 
@@ -247,6 +513,9 @@ func (t *task) try_unlegacy() {
 // 如果在执行期间，这些依赖条件的值发生过变更
 // 那么就表明此任务需要被重做
 func (t *task) changed() bool {
+	if t.dirty {
+		return true
+	}
 	for name, r_value := range t.r_values {
 		rw_value := t.rw_values[name]
 		if rw_value == nil {
@@ -279,6 +548,7 @@ func (t *task) listenLocalRead(name interface{}) {
 	}
 
 	value, cmsg, _ := t.cg.inspect(name)
+	t.reads = append(t.reads, name)
 	t.r_values[name] = &cValue{
 		value:  value,
 		cmsg:   cmsg,
@@ -288,7 +558,12 @@ func (t *task) listenLocalRead(name interface{}) {
 
 func (t *task) listenLocalWrite(names ...interface{}) {
 	for _, name := range names {
-		value, cmsg, _ := t.cg.inspect(name)
+		value, cmsg, ok := t.cg.inspect(name)
+		if ok == false {
+			// retracted
+			delete(t.w_values, name)
+			continue
+		}
 		t.w_values[name] = &cValue{
 			value:  value,
 			cmsg:   cmsg,
@@ -297,6 +572,15 @@ func (t *task) listenLocalWrite(names ...interface{}) {
 	}
 }
 
+// 阻塞等待条件期间让出执行名额
+func (t *task) listenLocalPark(parked bool) {
+	if parked {
+		t.leave()
+	} else {
+		t.enter()
+	}
+}
+
 // 监控全局的条件变更行为，一旦发现变更的条件是此任务所依赖的
 // 则触发一次redo
 func (t *task) listenGlobalWrite(names ...interface{}) {
@@ -305,8 +589,12 @@ func (t *task) listenGlobalWrite(names ...interface{}) {
 	t.fx_lock.Lock()
 	for _, name := range names {
 		if _, ok := t.r_values[name]; ok {
+			value, cmsg, ok := t.cg.inspect(name)
+			if ok == false {
+				// 条件被retract，等到再次被满足时才能确认是否需要重做
+				continue
+			}
 			impact++
-			value, cmsg, _ := t.cg.inspect(name)
 			t.rw_values[name] = &cValue{
 				value:  value,
 				cmsg:   cmsg,
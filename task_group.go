@@ -2,6 +2,7 @@ package sudu
 
 import (
 	"sync"
+	"time"
 )
 
 // 一个waitgroup的高级封装
@@ -17,29 +18,57 @@ type TaskGroup struct {
 	Tasks   int
 	Running int
 	WaitAll bool
+	// 任务失败后重试的总次数，参见RetryPolicy
+	Retries int
+	// 任务声明的命名资源，默认每个TaskGroup独立，可以替换为共享的Resources
+	Resources *Resources
+
+	// 所有任务的goroutine，不受WaitAll影响，用于Drain
+	alive sync.WaitGroup
 }
 
 func NewTaskGroup() *TaskGroup {
-	return &TaskGroup{
-		lock: sync.Mutex{},
-		wg:   sync.WaitGroup{},
+	tg := &TaskGroup{}
+	tg.init()
+	return tg
+}
 
-		fx_lock: sync.Mutex{},
-	}
+func (tg *TaskGroup) init() {
+	tg.Resources = NewResources()
 }
 
 // if fx panic()，the Wait will be unblock immediately by default (controled by WaitAll)
 // if fx first panic(error), the Wait will return the error
 // if fx first panic(anyother), the Wait will panic as the same
 // otherwise, Wait return nil
+// 任务中可以继续调用Go添加新的任务（例如递归地遍历一棵树），Wait会一并等待它们
 func (tg *TaskGroup) Go(fxs ...func()) {
+	tg.GoWith(TaskOptions{}, fxs...)
+}
+
+// opts.Priority只在等待资源时有效
+// TaskGroup无法得知任务何时阻塞，资源在整个执行期间（包括重试）都被占用
+func (tg *TaskGroup) GoWith(opts TaskOptions, fxs ...func()) {
 	tg.lock.Lock()
 	defer tg.lock.Unlock()
 
 	wg := sync.WaitGroup{}
 	for _, fx := range fxs {
+		fx := fx
+
+		// 在调用方中登记，调用方如果是正在运行的任务，计数不会在此期间归零
+		tg.fx_lock.Lock()
+		if tg.fx_panic == nil || tg.WaitAll {
+			tg.wg.Add(1)
+		}
+		tg.Tasks++
+		tg.Running++
+		tg.fx_lock.Unlock()
+
 		wg.Add(1)
+		tg.alive.Add(1)
 		go func() {
+			defer tg.alive.Done()
 			defer func() {
 				tg.fx_lock.Lock()
 				defer tg.fx_lock.Unlock()
@@ -63,35 +92,101 @@ func (tg *TaskGroup) Go(fxs ...func()) {
 				}
 			}()
 
-			tg.fx_lock.Lock()
-			if tg.fx_panic == nil || tg.WaitAll {
-				tg.wg.Add(1)
-			}
-			tg.fx_lock.Unlock()
-
-			tg.Tasks++
-			tg.Running++
 			wg.Done()
 
-			fx()
+			tg.run(fx, opts)
 		}()
 	}
 	wg.Wait()
 }
 
-func (tg *TaskGroup) Wait() error {
-	tg.lock.Lock()
-	defer tg.lock.Unlock()
+// 按照重试以及对冲策略执行fx，最终的失败以panic的方式交给Go处理
+func (tg *TaskGroup) run(fx func(), opts TaskOptions) {
+	resources := sortedResources(opts.Resources)
+	pool := tg.Resources
+	if hedge := opts.Hedge; hedge != nil {
+		// 每次执行分别占用资源的名额
+		single := fx
+		fx = func() {
+			_, p := hedge.race(tg.spawn, func(int) interface{} {
+				if len(resources) > 0 {
+					pool.acquire(resources, opts.Priority, 0)
+					defer pool.release(resources)
+				}
+				return try(single)
+			})
+			if p != nil {
+				panic(p)
+			}
+		}
+	} else if len(resources) > 0 {
+		pool.acquire(resources, opts.Priority, 0)
+		defer pool.release(resources)
+	}
 
+	retry := opts.Retry
+	for attempt := 1; ; attempt++ {
+		p := try(fx)
+		if p == nil {
+			return
+		}
+
+		backoff, ok := retry.next(attempt, p)
+		if ok {
+			tg.fx_lock.Lock()
+			ok = tg.fx_panic == nil || tg.WaitAll
+			if ok {
+				tg.Retries++
+			}
+			tg.fx_lock.Unlock()
+		}
+		if ok == false {
+			panic(p)
+		}
+		retry.retried(attempt, p)
+		time.Sleep(backoff)
+	}
+}
+
+// 追踪对冲执行的goroutine，Drain会等待它们
+func (tg *TaskGroup) spawn(fx func()) {
+	tg.alive.Add(1)
+	go func() {
+		defer tg.alive.Done()
+		fx()
+	}()
+}
+
+func try(fx func()) (p interface{}) {
+	defer func() {
+		p = recover()
+	}()
+
+	fx()
+	return
+}
+
+// 等待所有任务的goroutine退出，包括Wait因为失败提前返回后仍在运行的任务
+// TaskGroup无法打断任务本身，任务需要自行结束，参见ConditionTask.Close
+func (tg *TaskGroup) Drain() {
+	tg.alive.Wait()
+}
+
+// 等待期间不持有tg.lock，任务可以继续调用Go
+func (tg *TaskGroup) Wait() error {
 	tg.wg.Wait()
 
-	if tg.fx_panic == nil {
+	tg.fx_lock.Lock()
+	p := tg.fx_panic
+	tg.fx_lock.Unlock()
+
+	if p == nil {
 		return nil
 	}
 
-	if err, ok := tg.fx_panic.(error); ok {
+	if err, ok := p.(error); ok {
 		return err
 	} else {
-		panic(tg.fx_panic)
+		panic(p)
 	}
 }
@@ -1,6 +1,7 @@
 package sudu
 
 import (
+	"sync/atomic"
 	"testing"
 )
 
@@ -38,3 +39,26 @@ func TestTaskGroup(t *testing.T) {
 		t.Errorf("tg.Running = %d, expect %d", tg.Running, 0)
 	}
 }
+
+func TestTaskGroupFanOut(t *testing.T) {
+	tg := NewTaskGroup()
+
+	var visited int32
+	var crawl func(depth int) func()
+	crawl = func(depth int) func() {
+		return func() {
+			atomic.AddInt32(&visited, 1)
+			if depth > 0 {
+				tg.Go(crawl(depth-1), crawl(depth-1))
+			}
+		}
+	}
+	tg.Go(crawl(4))
+
+	if err := tg.Wait(); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if n := atomic.LoadInt32(&visited); n != 31 {
+		t.Errorf("visited = %d, expect %d", n, 31)
+	}
+}